@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ahoy"
+
+	"go.lsp.dev/uri"
+)
+
+const (
+	// analysisDebounce is how long edits must pause before a reparse starts
+	analysisDebounce = 150 * time.Millisecond
+	// defaultParseTimeout bounds a single tokenize/parse run
+	defaultParseTimeout = 5 * time.Second
+	// defaultMaxDocumentSize is the largest document that will be parsed
+	defaultMaxDocumentSize = 5000000
+)
+
+// documentWorker owns the live text of one open document and schedules its
+// analysis. Analysis results are published as immutable Document snapshots.
+type documentWorker struct {
+	mu      sync.Mutex
+	uri     uri.URI
+	content string
+	version int32
+	timer   *time.Timer
+	cancel  context.CancelFunc // Cancels the in-flight analysis, if any
+}
+
+// schedule (re)starts the debounce timer for the worker's current version
+func (s *Server) schedule(w *documentWorker, delay time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	version := w.version
+	w.timer = time.AfterFunc(delay, func() {
+		s.analyze(w, version)
+	})
+}
+
+// stop cancels any pending or running analysis for the worker
+func (w *documentWorker) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.cancel != nil {
+		w.cancel()
+		w.cancel = nil
+	}
+}
+
+// analyze parses the given version of the worker's text and publishes the
+// snapshot. Stale versions are dropped before and after parsing.
+func (s *Server) analyze(w *documentWorker, version int32) {
+	w.mu.Lock()
+	if w.version != version {
+		w.mu.Unlock()
+		return
+	}
+
+	// A newer version supersedes whatever is still being parsed
+	if w.cancel != nil {
+		w.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	content := w.content
+	w.mu.Unlock()
+
+	defer cancel()
+
+	doc := parseDocument(ctx, w.uri, content, version, s.parseTimeout())
+	if doc == nil {
+		debugLog.Printf("Analysis of %s version %d cancelled", w.uri, version)
+		return
+	}
+	s.publishAnalysis(w, doc)
+}
+
+// publishAnalysis publishes an analyzed snapshot of the worker's text,
+// indexes it and pushes its diagnostics if it is still the newest version
+func (s *Server) publishAnalysis(w *documentWorker, doc *Document) {
+	version := doc.Version
+	if !s.publishSnapshot(doc) {
+		return
+	}
+	s.workspace.update(doc)
+
+	// Clients that pull diagnostics ask for them when they need them
+	if w.isLatest(version) && s.pushesDiagnostics() {
+		s.publishDiagnostics(context.Background(), doc)
+	}
+}
+
+// isLatest reports whether version is still the newest text of the document
+func (w *documentWorker) isLatest(version int32) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.version == version
+}
+
+// publishSnapshot makes doc visible to readers unless a newer snapshot is
+// already published or the document has been closed
+func (s *Server) publishSnapshot(doc *Document) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, open := s.workers[doc.URI]; !open {
+		return false
+	}
+	if current := s.documents[doc.URI]; current != nil && current.Version >= doc.Version {
+		return false
+	}
+
+	// Readers may still hold the previous snapshot, so it is left for the GC
+	// rather than cleared
+	s.documents[doc.URI] = doc
+	return true
+}
+
+// liveDocument returns an analyzed snapshot of the document's current
+// text. Requests that edit the document, like formatting, can't use a
+// published snapshot that lags behind the editor, so the text is parsed on
+// demand in that case. Text over the document size limit, which the worker
+// stopped analyzing, is never parsed; see oversizedDocument.
+func (s *Server) liveDocument(ctx context.Context, docURI uri.URI) *Document {
+	worker := s.getWorker(docURI)
+	if worker == nil {
+		return nil
+	}
+
+	worker.mu.Lock()
+	content, version := worker.content, worker.version
+	worker.mu.Unlock()
+
+	if doc := s.getDocument(docURI); doc != nil && doc.Version == version {
+		return doc
+	}
+	if limit := s.getSettings().Limits.MaxDocumentSize; len(content) > limit {
+		return oversizedDocument(docURI, content, version, limit)
+	}
+	return parseDocument(ctx, docURI, content, version, s.parseTimeout())
+}
+
+// oversizedDocument is the snapshot of text over the document size limit.
+// It isn't parsed, so it has no AST or symbols and its only error says why.
+func oversizedDocument(docURI uri.URI, content string, version int32, limit int) *Document {
+	return &Document{
+		URI:         docURI,
+		Content:     content,
+		Lines:       strings.Split(content, "\n"),
+		Version:     version,
+		SymbolTable: NewSymbolTable(),
+		Errors: []ahoy.ParseError{
+			{
+				Line:    1,
+				Column:  1,
+				Message: fmt.Sprintf("File too large (%d bytes, the limit is %d) - not analyzed", len(content), limit),
+			},
+		},
+	}
+}
+
+func (s *Server) parseTimeout() time.Duration {
+	return time.Duration(s.getSettings().Limits.ParseTimeout)
+}
+
+// parseDocument tokenizes, parses and builds the symbol table for content.
+// It returns nil if ctx is cancelled first.
+//
+// The ahoy parser takes no context and can't be interrupted, so cancelling
+// (or timing out) only stops waiting for it: the goroutine below runs to
+// completion and its result is discarded. Each superseded analysis can
+// therefore leave a parse running, and fast typing in a large document can
+// have several in flight at once; the debounce in schedule and the document
+// size limit are what keep that bounded.
+func parseDocument(ctx context.Context, docURI uri.URI, content string, version int32, timeout time.Duration) *Document {
+	doc := &Document{
+		URI:     docURI,
+		Content: content,
+		Lines:   strings.Split(content, "\n"),
+		Version: version,
+	}
+
+	var tokens []ahoy.Token
+	var ast *ahoy.ASTNode
+	var errors []ahoy.ParseError
+	parseDone := make(chan struct{})
+
+	// Not cancellable, see above
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				// Parser panicked - create error diagnostic
+				debugLog.Printf("Parser panic: %v", r)
+				errors = []ahoy.ParseError{
+					{
+						Line:    1,
+						Column:  1,
+						Message: fmt.Sprintf("Parser error: %v", r),
+					},
+				}
+				ast = nil
+				tokens = nil
+			}
+			close(parseDone)
+		}()
+
+		tokens = ahoy.Tokenize(content)
+		debugLog.Printf("Tokenized: %d tokens", len(tokens))
+		ast, errors = ahoy.ParseLint(tokens)
+		debugLog.Printf("Parsed: %d errors", len(errors))
+	}()
+
+	// Wait for parsing with a timeout for safety
+	select {
+	case <-parseDone:
+		doc.Tokens = tokens
+		doc.AST = ast
+		doc.Errors = errors
+	case <-ctx.Done():
+		return nil
+	case <-time.After(timeout):
+		debugLog.Printf("Parser timeout after %v!", timeout)
+		doc.Errors = []ahoy.ParseError{
+			{
+				Line:    1,
+				Column:  1,
+				Message: "Parser timeout - file may be too complex",
+			},
+		}
+	}
+
+	// Build symbol table - only if AST exists
+	if doc.AST != nil {
+		spans, nodeTokens := computeNodeSpans(doc.AST, doc.Tokens, doc.Lines)
+		doc.Spans = spans
+		doc.SymbolTable = BuildSymbolTable(doc.AST, nodeTokens, doc.Lines)
+	} else {
+		doc.SymbolTable = NewSymbolTable()
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return doc
+}
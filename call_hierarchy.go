@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"ahoy"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// functionDecl is a NODE_FUNCTION declaration and the document it is in
+type functionDecl struct {
+	doc  *Document
+	node *ahoy.ASTNode
+}
+
+// callSite is one NODE_CALL and the function whose body contains it. A nil
+// caller means the call is in top-level code.
+type callSite struct {
+	call   *ahoy.ASTNode
+	caller *ahoy.ASTNode
+}
+
+func (s *Server) handlePrepareCallHierarchy(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.CallHierarchyPrepareParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil || doc.AST == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	line := int(params.Position.Line) + 1
+	column := sourceColumn(doc.Lines, params.Position)
+
+	// A function declared in this document resolves through the symbol
+	// table; anything else is looked up by name across the workspace
+	var decl *functionDecl
+	if sym, _ := doc.SymbolTable.ReferenceAt(line, column); sym != nil {
+		if sym.Kind != SymbolKindFunction {
+			return reply(ctx, nil, nil)
+		}
+		if node := findFunctionNode(doc.AST, sym.Name, sym.Line); node != nil {
+			decl = &functionDecl{doc, node}
+		}
+	}
+	if decl == nil {
+		if word, ok := wordSpan(doc.Lines, line, column); ok {
+			name := doc.Lines[line-1][word.StartColumn:word.EndColumn]
+			decl = s.resolveFunction(doc, name)
+		}
+	}
+	if decl == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	return reply(ctx, []protocol.CallHierarchyItem{functionItem(decl.doc, decl.node)}, nil)
+}
+
+func (s *Server) handleIncomingCalls(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.CallHierarchyIncomingCallsParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	target := s.itemFunction(params.Item)
+	if target == nil {
+		return reply(ctx, []protocol.CallHierarchyIncomingCall{}, nil)
+	}
+
+	docs := s.workspace.documents()
+	callees := newCalleeResolver(docs, target.node.Value)
+	results := []protocol.CallHierarchyIncomingCall{}
+	for _, doc := range docs {
+		if doc.AST == nil {
+			continue
+		}
+
+		// Every call by this name in the document resolves the same way
+		if callee := callees.resolve(doc); callee == nil || callee.node != target.node {
+			continue
+		}
+
+		// Group call sites by the function they appear in
+		byCaller := map[*ahoy.ASTNode]int{}
+		for _, site := range collectCallSites(doc.AST) {
+			if site.call.Value != target.node.Value {
+				continue
+			}
+
+			index, seen := byCaller[site.caller]
+			if !seen {
+				index = len(results)
+				byCaller[site.caller] = index
+
+				from := fileItem(doc)
+				if site.caller != nil {
+					from = functionItem(doc, site.caller)
+				}
+				results = append(results, protocol.CallHierarchyIncomingCall{From: from})
+			}
+			results[index].FromRanges = append(results[index].FromRanges, callSiteRange(doc, site.call))
+		}
+	}
+
+	return reply(ctx, results, nil)
+}
+
+func (s *Server) handleOutgoingCalls(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.CallHierarchyOutgoingCallsParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	source := s.itemFunction(params.Item)
+	if source == nil {
+		return reply(ctx, []protocol.CallHierarchyOutgoingCall{}, nil)
+	}
+
+	results := []protocol.CallHierarchyOutgoingCall{}
+	byCallee := map[*ahoy.ASTNode]int{}
+	resolved := map[string]*functionDecl{}
+	for _, site := range collectCallSites(source.node) {
+		if site.caller != source.node {
+			// Calls made by a nested function belong to that function
+			continue
+		}
+
+		callee, seen := resolved[site.call.Value]
+		if !seen {
+			callee = s.resolveFunction(source.doc, site.call.Value)
+			resolved[site.call.Value] = callee
+		}
+		if callee == nil {
+			// Built-ins and unknown functions have nowhere to navigate to
+			continue
+		}
+
+		index, seen := byCallee[callee.node]
+		if !seen {
+			index = len(results)
+			byCallee[callee.node] = index
+			results = append(results, protocol.CallHierarchyOutgoingCall{To: functionItem(callee.doc, callee.node)})
+		}
+		results[index].FromRanges = append(results[index].FromRanges, callSiteRange(source.doc, site.call))
+	}
+
+	return reply(ctx, results, nil)
+}
+
+// documentFor returns the latest snapshot of a document, open or indexed
+func (s *Server) documentFor(docURI uri.URI) *Document {
+	if doc := s.getDocument(docURI); doc != nil {
+		return doc
+	}
+	return s.workspace.document(docURI)
+}
+
+// itemFunction finds the declaration a call hierarchy item refers to
+func (s *Server) itemFunction(item protocol.CallHierarchyItem) *functionDecl {
+	doc := s.documentFor(item.URI)
+	if doc == nil || doc.AST == nil {
+		return nil
+	}
+
+	node := findFunctionNode(doc.AST, item.Name, int(item.SelectionRange.Start.Line)+1)
+	if node == nil {
+		return nil
+	}
+	return &functionDecl{doc, node}
+}
+
+// resolveFunction finds the function a call by name refers to. Functions
+// in the calling document win over those in other workspace files.
+func (s *Server) resolveFunction(doc *Document, name string) *functionDecl {
+	if node := findFunctionNode(doc.AST, name, 0); node != nil {
+		return &functionDecl{doc, node}
+	}
+
+	for _, other := range s.workspace.documents() {
+		if other.URI == doc.URI {
+			continue
+		}
+		if node := findFunctionNode(other.AST, name, 0); node != nil {
+			return &functionDecl{other, node}
+		}
+	}
+
+	return nil
+}
+
+// calleeResolver resolves calls to one function name the way
+// resolveFunction does, for many documents. A document that doesn't
+// declare the function calls the first declaration in the workspace, so
+// that is looked up once rather than for every document.
+type calleeResolver struct {
+	name   string
+	shared *functionDecl
+}
+
+func newCalleeResolver(docs []*Document, name string) calleeResolver {
+	r := calleeResolver{name: name}
+	for _, doc := range docs {
+		if node := findFunctionNode(doc.AST, name, 0); node != nil {
+			r.shared = &functionDecl{doc, node}
+			break
+		}
+	}
+	return r
+}
+
+// resolve returns the declaration calls to the name in doc refer to, or nil
+func (r calleeResolver) resolve(doc *Document) *functionDecl {
+	if node := findFunctionNode(doc.AST, r.name, 0); node != nil {
+		return &functionDecl{doc, node}
+	}
+	return r.shared
+}
+
+// findFunctionNode returns the NODE_FUNCTION called name, declared on the
+// given (1-based) line if line is not 0
+func findFunctionNode(node *ahoy.ASTNode, name string, line int) *ahoy.ASTNode {
+	var found *ahoy.ASTNode
+
+	var walk func(*ahoy.ASTNode, int)
+	walk = func(n *ahoy.ASTNode, depth int) {
+		if n == nil || found != nil || depth > 1000 {
+			return
+		}
+		if n.Type == ahoy.NODE_FUNCTION && n.Value == name && (line == 0 || n.Line == line) {
+			found = n
+			return
+		}
+		for _, child := range n.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(node, 0)
+
+	return found
+}
+
+// collectCallSites returns every NODE_CALL under node along with the
+// innermost function containing it
+func collectCallSites(node *ahoy.ASTNode) []callSite {
+	sites := []callSite{}
+
+	var walk func(n, caller *ahoy.ASTNode, depth int)
+	walk = func(n, caller *ahoy.ASTNode, depth int) {
+		if n == nil || depth > 1000 {
+			return
+		}
+
+		switch n.Type {
+		case ahoy.NODE_FUNCTION:
+			caller = n
+		case ahoy.NODE_CALL:
+			sites = append(sites, callSite{call: n, caller: caller})
+		}
+
+		for _, child := range n.Children {
+			walk(child, caller, depth+1)
+		}
+	}
+
+	var caller *ahoy.ASTNode
+	if node != nil && node.Type == ahoy.NODE_FUNCTION {
+		caller = node
+	}
+	if node != nil {
+		for _, child := range node.Children {
+			walk(child, caller, 0)
+		}
+	}
+
+	return sites
+}
+
+// functionItem describes a function declaration for the call hierarchy
+func functionItem(doc *Document, node *ahoy.ASTNode) protocol.CallHierarchyItem {
+	line, column := node.Line, 0
+	span, ok := doc.Spans[node]
+	if ok {
+		line, column = span.StartLine, span.StartColumn
+	}
+	selection := nameRangeOnLine(doc, line, column, node.Value)
+
+	rng := selection
+	if ok {
+		rng = span.toRange(doc.Lines)
+	}
+
+	detail := ""
+	if sig := collectFunctionSignatures(node)[node.Value]; sig != nil {
+		detail = functionSignatureInformation(sig).Label
+	}
+
+	return protocol.CallHierarchyItem{
+		Name:           node.Value,
+		Kind:           protocol.SymbolKindFunction,
+		Detail:         detail,
+		URI:            doc.URI,
+		Range:          rng,
+		SelectionRange: selection,
+	}
+}
+
+// fileItem stands for the top-level code of a document, which can call
+// functions without being inside one
+func fileItem(doc *Document) protocol.CallHierarchyItem {
+	end := protocol.Position{}
+	if len(doc.Lines) > 0 {
+		end = lspPosition(doc.Lines, len(doc.Lines), len(doc.Lines[len(doc.Lines)-1]))
+	}
+
+	return protocol.CallHierarchyItem{
+		Name:           filepath.Base(doc.URI.Filename()),
+		Kind:           protocol.SymbolKindFile,
+		URI:            doc.URI,
+		Range:          protocol.Range{End: end},
+		SelectionRange: protocol.Range{},
+	}
+}
+
+// callSiteRange is the range of the callee name at a call
+func callSiteRange(doc *Document, call *ahoy.ASTNode) protocol.Range {
+	line, column := call.Line, 0
+	if span, ok := doc.Spans[call]; ok {
+		line, column = span.StartLine, span.StartColumn
+	}
+	return nameRangeOnLine(doc, line, column, call.Value)
+}
+
+// nameRangeOnLine locates name on a (1-based) line as a whole identifier
+// at or after column. Callers pass the start of the node's span, which is
+// the token the parser claimed for it, so the first match is that token.
+func nameRangeOnLine(doc *Document, line, column int, name string) protocol.Range {
+	start := column
+	if line > 0 && line <= len(doc.Lines) {
+		if i := identifierColumn(doc.Lines[line-1], column, name); i >= 0 {
+			start = i
+		}
+	}
+
+	return lspRange(doc.Lines, line, start, line, start+len(name))
+}
+
+// identifierColumn returns the column of the first occurrence of name at or
+// after from that is a whole identifier in code, or -1. Matches inside
+// longer names, string literals and comments don't count.
+func identifierColumn(text string, from int, name string) int {
+	if name == "" {
+		return -1
+	}
+
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		if isQuote(ch) {
+			quote = ch
+			continue
+		}
+		if ch == '?' {
+			break
+		}
+
+		if i < from || !strings.HasPrefix(text[i:], name) {
+			continue
+		}
+		end := i + len(name)
+		if (i == 0 || !isWordChar(rune(text[i-1]))) && (end == len(text) || !isWordChar(rune(text[end]))) {
+			return i
+		}
+	}
+
+	return -1
+}
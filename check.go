@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// checkResult is the diagnostics of one checked file
+type checkResult struct {
+	path        string
+	uri         uri.URI
+	diagnostics []protocol.Diagnostic
+}
+
+// runCheck implements `ahoy-lsp check`: it runs the editor's diagnostics
+// over files and directories without a client and returns the exit code.
+// The exit code is 1 when there are more errors or warnings than allowed,
+// and 2 when the check itself fails.
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: ahoy-lsp check [flags] [path ...]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Checks .ahoy files, searching directories recursively. The default path is")
+		fmt.Fprintln(stderr, "the current directory.")
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "settings file (default: "+projectConfigFile+" in the current directory, if any)")
+	maxErrors := flags.Int("max-errors", 0, "number of errors allowed before the check fails")
+	maxWarnings := flags.Int("max-warnings", -1, "number of warnings allowed before the check fails; -1 allows any number")
+	format := flags.String("format", "text", "output format: text, json (one diagnostic per line) or sarif")
+	verbose := flags.Bool("verbose", false, "log parser and analysis details to stderr")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	write, ok := checkFormats[*format]
+	if !ok {
+		fmt.Fprintf(stderr, "ahoy-lsp: unknown format %q\n", *format)
+		return 2
+	}
+
+	if !*verbose {
+		debugLog.SetOutput(io.Discard)
+	}
+
+	config, err := checkSettings(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "ahoy-lsp: %v\n", err)
+		return 2
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := checkFiles(paths)
+	if err != nil {
+		fmt.Fprintf(stderr, "ahoy-lsp: %v\n", err)
+		return 2
+	}
+
+	results := []checkResult{}
+	for _, path := range files {
+		result, err := checkFile(path, config)
+		if err != nil {
+			fmt.Fprintf(stderr, "ahoy-lsp: %v\n", err)
+			return 2
+		}
+		results = append(results, result)
+	}
+
+	errors, warnings := countSeverities(results)
+	if err := write(stdout, results); err != nil {
+		fmt.Fprintf(stderr, "ahoy-lsp: %v\n", err)
+		return 2
+	}
+	fmt.Fprintf(stderr, "%d files checked: %d errors, %d warnings\n", len(results), errors, warnings)
+
+	if errors > *maxErrors || (*maxWarnings >= 0 && warnings > *maxWarnings) {
+		return 1
+	}
+	return 0
+}
+
+// checkSettings reads the settings used by a check: the given file, or the
+// project file in the current directory if there is one
+func checkSettings(path string) (settings, error) {
+	config := defaultSettings()
+
+	var layers []json.RawMessage
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+		layers = append(layers, data)
+	} else if dir, err := os.Getwd(); err == nil {
+		layers = loadProjectSettings([]string{dir})
+	}
+
+	for _, layer := range layers {
+		merged, err := mergeSettings(config, layer)
+		if err != nil {
+			return config, fmt.Errorf("invalid settings: %v", err)
+		}
+		config = merged
+	}
+	config.normalize()
+	return config, nil
+}
+
+// checkFiles expands the paths into the .ahoy files to check, sorted.
+// Files named explicitly are checked whatever their extension; hidden
+// directories are skipped like in the workspace index.
+func checkFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if file != path && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(file) == ".ahoy" {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// checkFile parses one file and computes its diagnostics like the server
+// does for an open document. A file that can't be read or is too large is
+// reported as an error on its first line, so the rest still get checked.
+func checkFile(path string, config settings) (checkResult, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return checkResult{}, err
+	}
+	documentURI := uri.File(absolute)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return fileErrorResult(path, documentURI, fmt.Sprintf("cannot read file: %v", err)), nil
+	}
+	if len(content) > config.Limits.MaxDocumentSize {
+		return fileErrorResult(path, documentURI, fmt.Sprintf("file too large (%d bytes, the limit is %d)", len(content), config.Limits.MaxDocumentSize)), nil
+	}
+
+	doc := parseDocument(context.Background(), documentURI, string(content), 0, time.Duration(config.Limits.ParseTimeout))
+	diagnostics := computeDiagnostics(doc, config.Lint)
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i].Range.Start, diagnostics[j].Range.Start
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Character < b.Character
+	})
+
+	return checkResult{path: path, uri: doc.URI, diagnostics: diagnostics}, nil
+}
+
+// fileErrorResult is the result for a file that couldn't be checked: a
+// single error at its start
+func fileErrorResult(path string, documentURI uri.URI, message string) checkResult {
+	return checkResult{
+		path: path,
+		uri:  documentURI,
+		diagnostics: []protocol.Diagnostic{{
+			Severity: protocol.DiagnosticSeverityError,
+			Source:   "ahoy",
+			Message:  message,
+		}},
+	}
+}
+
+// countSeverities counts the errors and warnings in the results
+func countSeverities(results []checkResult) (errors, warnings int) {
+	for _, result := range results {
+		for _, diagnostic := range result.diagnostics {
+			switch diagnostic.Severity {
+			case protocol.DiagnosticSeverityError:
+				errors++
+			case protocol.DiagnosticSeverityWarning:
+				warnings++
+			}
+		}
+	}
+	return errors, warnings
+}
+
+// writeText prints one diagnostic per line as file:line:column, with
+// 1-based lines and columns like compilers use
+func writeText(w io.Writer, results []checkResult) {
+	for _, result := range results {
+		for _, diagnostic := range result.diagnostics {
+			start := diagnostic.Range.Start
+			fmt.Fprintf(w, "%s:%d:%d: %s: %s", result.path, start.Line+1, start.Character+1,
+				severityName(diagnostic.Severity), diagnostic.Message)
+			if code, ok := diagnostic.Code.(string); ok && code != "" {
+				fmt.Fprintf(w, " [%s]", code)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// severityName is the lower-case name of a severity, as used in the lint
+// settings
+func severityName(severity protocol.DiagnosticSeverity) string {
+	switch severity {
+	case protocol.DiagnosticSeverityWarning:
+		return "warning"
+	case protocol.DiagnosticSeverityInformation:
+		return "information"
+	case protocol.DiagnosticSeverityHint:
+		return "hint"
+	}
+	return "error"
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// checkFormats are the output formats of `ahoy-lsp check`
+var checkFormats = map[string]func(io.Writer, []checkResult) error{
+	"text": func(w io.Writer, results []checkResult) error {
+		writeText(w, results)
+		return nil
+	},
+	"json":  writeJSONLines,
+	"sarif": writeSARIF,
+}
+
+// jsonDiagnostic is one line of the JSON lines output: an LSP diagnostic
+// with the file it belongs to
+type jsonDiagnostic struct {
+	Path string  `json:"path"`
+	URI  uri.URI `json:"uri"`
+	protocol.Diagnostic
+}
+
+// writeJSONLines prints one JSON object per diagnostic. The fields are
+// those of protocol.Diagnostic, with 0-based positions, plus the file path
+// and URI.
+func writeJSONLines(w io.Writer, results []checkResult) error {
+	encoder := json.NewEncoder(w)
+	for _, result := range results {
+		for _, diagnostic := range result.diagnostics {
+			if err := encoder.Encode(jsonDiagnostic{result.path, result.uri, diagnostic}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The subset of SARIF 2.1.0 written by writeSARIF
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId,omitempty"`
+	RuleIndex *int            `json:"ruleIndex,omitempty"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+// sarifRegion positions are 1-based
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+// writeSARIF prints the results as a SARIF 2.1.0 log for code review and
+// code scanning tools. Each diagnostic code that occurs becomes a rule, in
+// the order of lintRules; syntax errors have no rule.
+func writeSARIF(w io.Writer, results []checkResult) error {
+	used := map[string]bool{}
+	for _, result := range results {
+		for _, diagnostic := range result.diagnostics {
+			if code, ok := diagnostic.Code.(string); ok {
+				used[code] = true
+			}
+		}
+	}
+
+	rules := []sarifRule{}
+	ruleIndex := map[string]int{}
+	for _, rule := range lintRules {
+		if used[rule.Code] {
+			ruleIndex[rule.Code] = len(rules)
+			rules = append(rules, sarifRule{
+				ID:                   rule.Code,
+				ShortDescription:     sarifMessage{Text: rule.Description},
+				DefaultConfiguration: sarifConfiguration{Level: sarifLevel(rule.Severity)},
+			})
+		}
+	}
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:    "ahoy-lsp",
+			Version: version,
+			Rules:   rules,
+		}},
+		// Diagnostic ranges count UTF-16 code units, like LSP positions
+		ColumnKind: "utf16CodeUnits",
+		Results:    []sarifResult{},
+	}
+
+	for _, result := range results {
+		artifact := sarifArtifact(result.path)
+		for _, diagnostic := range result.diagnostics {
+			entry := sarifResult{
+				Level:   sarifLevel(diagnostic.Severity),
+				Message: sarifMessage{Text: diagnostic.Message},
+				Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: artifact,
+					Region:           sarifRange(diagnostic.Range),
+				}}},
+			}
+			if code, ok := diagnostic.Code.(string); ok && code != "" {
+				entry.RuleID = code
+				if index, ok := ruleIndex[code]; ok {
+					entry.RuleIndex = &index
+				}
+			}
+			run.Results = append(run.Results, entry)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
+
+// sarifArtifact locates a checked file. Relative paths are relative to the
+// source root, so the log doesn't depend on where the check ran.
+func sarifArtifact(path string) sarifArtifactLocation {
+	if filepath.IsAbs(path) {
+		return sarifArtifactLocation{URI: string(uri.File(path))}
+	}
+	return sarifArtifactLocation{URI: filepath.ToSlash(filepath.Clean(path)), URIBaseID: "%SRCROOT%"}
+}
+
+// sarifRange converts a 0-based LSP range
+func sarifRange(rng protocol.Range) sarifRegion {
+	region := sarifRegion{
+		StartLine:   int(rng.Start.Line) + 1,
+		StartColumn: int(rng.Start.Character) + 1,
+		EndLine:     int(rng.End.Line) + 1,
+		EndColumn:   int(rng.End.Character) + 1,
+	}
+	// An empty range still covers the character it starts at
+	if region.EndLine == region.StartLine && region.EndColumn <= region.StartColumn {
+		region.EndColumn = region.StartColumn + 1
+	}
+	return region
+}
+
+func sarifLevel(severity protocol.DiagnosticSeverity) string {
+	switch severity {
+	case protocol.DiagnosticSeverityError:
+		return "error"
+	case protocol.DiagnosticSeverityWarning:
+		return "warning"
+	}
+	return "note"
+}
@@ -318,7 +318,7 @@ func generateContextActions(doc *Document, rng protocol.Range) []protocol.CodeAc
 								},
 								End: protocol.Position{
 									Line:      rng.Start.Line,
-									Character: uint32(len(line)),
+									Character: uint32(utf16Length(line)),
 								},
 							},
 							NewText: strings.ReplaceAll(line, " plus ", " + "),
@@ -345,7 +345,7 @@ func generateContextActions(doc *Document, rng protocol.Range) []protocol.CodeAc
 								},
 								End: protocol.Position{
 									Line:      rng.Start.Line,
-									Character: uint32(len(line)),
+									Character: uint32(utf16Length(line)),
 								},
 							},
 							NewText: strings.ReplaceAll(line, " minus ", " - "),
@@ -372,7 +372,7 @@ func generateContextActions(doc *Document, rng protocol.Range) []protocol.CodeAc
 								},
 								End: protocol.Position{
 									Line:      rng.Start.Line,
-									Character: uint32(len(line)),
+									Character: uint32(utf16Length(line)),
 								},
 							},
 							NewText: strings.ReplaceAll(line, " times ", " * "),
@@ -399,7 +399,7 @@ func generateContextActions(doc *Document, rng protocol.Range) []protocol.CodeAc
 								},
 								End: protocol.Position{
 									Line:      rng.Start.Line,
-									Character: uint32(len(line)),
+									Character: uint32(utf16Length(line)),
 								},
 							},
 							NewText: strings.ReplaceAll(line, " is ", " == "),
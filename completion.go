@@ -28,25 +28,26 @@ func (s *Server) handleCompletion(ctx context.Context, reply jsonrpc2.Replier, r
 	}
 
 	currentLine := doc.Lines[params.Position.Line]
+	column := byteColumn(currentLine, int(params.Position.Character))
 
 	// Additional safety checks
 	if len(currentLine) > 10000 {
 		return reply(ctx, protocol.CompletionList{Items: items}, nil)
 	}
 
-	if int(params.Position.Character) > len(currentLine) || int(params.Position.Character) < 0 {
+	if column > len(currentLine) || column < 0 {
 		return reply(ctx, protocol.CompletionList{Items: items}, nil)
 	}
 
 	// Get the word being typed
 	prefix := ""
-	if params.Position.Character > 0 {
-		start := int(params.Position.Character) - 1
+	if column > 0 {
+		start := column - 1
 		for start >= 0 && (isIdentifierChar(rune(currentLine[start])) || currentLine[start] == '_') {
 			start--
 		}
 		start++
-		prefix = currentLine[start:params.Position.Character]
+		prefix = currentLine[start:column]
 	}
 	
 	// Check if we're after a dot (.) for method completion
@@ -54,9 +55,9 @@ func (s *Server) handleCompletion(ctx context.Context, reply jsonrpc2.Replier, r
 	beforePrefix := ""
 	beforePrefixType := "" // Track if we detected a literal type
 	
-	if params.Position.Character > 0 {
+	if column > 0 {
 		// Look back from the prefix to find if there's a dot
-		checkPos := int(params.Position.Character) - len(prefix) - 1
+		checkPos := column - len(prefix) - 1
 		if checkPos >= 0 && checkPos < len(currentLine) && currentLine[checkPos] == '.' {
 			// We're after a dot, find what's before it
 			identEnd := checkPos - 1
@@ -142,13 +143,13 @@ func (s *Server) handleCompletion(ctx context.Context, reply jsonrpc2.Replier, r
 			return reply(ctx, protocol.CompletionList{IsIncomplete: false, Items: items}, nil)
 		}
 		
-		// Build symbol table to look up the type
-		if doc.AST != nil {
-			symbolTable := BuildSymbolTable(doc.AST)
-			defer symbolTable.Clear()
-			
-			// Look up the variable/identifier before the dot
-			if sym := symbolTable.Lookup(beforePrefix); sym != nil {
+		// Look up the type in the document's symbol table
+		if doc.AST != nil && doc.SymbolTable != nil {
+			symbolTable := doc.SymbolTable
+
+			// Look up the variable/identifier before the dot in the scope
+			// containing the cursor
+			if sym := symbolTable.LookupAt(beforePrefix, int(params.Position.Line)+1, column); sym != nil {
 				// Don't provide method completions for constants
 				if sym.Kind == SymbolKindConstant {
 					// Return empty completion list for constants
@@ -175,7 +176,7 @@ func (s *Server) handleCompletion(ctx context.Context, reply jsonrpc2.Replier, r
 				}
 				
 				// Check if it's a struct type (only after checking built-in types)
-				if sym.Kind == SymbolKindVariable || sym.Kind == SymbolKindConstant {
+				if sym.Kind == SymbolKindVariable || sym.Kind == SymbolKindParameter || sym.Kind == SymbolKindConstant {
 					// Get struct fields based on the variable's type
 					fields := symbolTable.GetStructFields(sym.Type)
 					if fields != nil && len(fields) > 0 {
@@ -262,13 +263,12 @@ func (s *Server) handleCompletion(ctx context.Context, reply jsonrpc2.Replier, r
 		}
 	}
 
-	// Add function completions from symbol table
-	if doc.AST != nil {
-		symbolTable := BuildSymbolTable(doc.AST)
-		defer symbolTable.Clear()
+	// Add completions for the symbols visible at the cursor
+	if doc.AST != nil && doc.SymbolTable != nil {
+		visible := doc.SymbolTable.SymbolsAt(int(params.Position.Line)+1, column)
 
 		// Add user-defined functions
-		for _, sym := range symbolTable.GlobalScope.Symbols {
+		for _, sym := range visible {
 			if sym.Kind == SymbolKindFunction {
 				if prefix == "" || strings.HasPrefix(sym.Name, prefix) {
 					// Build function signature for detail
@@ -286,9 +286,9 @@ func (s *Server) handleCompletion(ctx context.Context, reply jsonrpc2.Replier, r
 			}
 		}
 
-		// Add variables in scope
-		for _, sym := range symbolTable.GlobalScope.Symbols {
-			if sym.Kind == SymbolKindVariable {
+		// Add variables and parameters in scope
+		for _, sym := range visible {
+			if sym.Kind == SymbolKindVariable || sym.Kind == SymbolKindParameter {
 				if prefix == "" || strings.HasPrefix(sym.Name, prefix) {
 					items = append(items, protocol.CompletionItem{
 						Label:  sym.Name,
@@ -300,7 +300,7 @@ func (s *Server) handleCompletion(ctx context.Context, reply jsonrpc2.Replier, r
 		}
 
 		// Add constants in scope
-		for _, sym := range symbolTable.GlobalScope.Symbols {
+		for _, sym := range visible {
 			if sym.Kind == SymbolKindConstant {
 				if prefix == "" || strings.HasPrefix(sym.Name, prefix) {
 					items = append(items, protocol.CompletionItem{
@@ -313,7 +313,7 @@ func (s *Server) handleCompletion(ctx context.Context, reply jsonrpc2.Replier, r
 		}
 
 		// Add enum values
-		for _, sym := range symbolTable.GlobalScope.Symbols {
+		for _, sym := range visible {
 			if sym.Kind == SymbolKindEnumValue {
 				if prefix == "" || strings.HasPrefix(sym.Name, prefix) {
 					items = append(items, protocol.CompletionItem{
@@ -338,102 +338,80 @@ func isIdentifierChar(ch rune) bool {
 	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
 }
 
+// builtinMethod describes a method available on a built-in type
+type builtinMethod struct {
+	label       string
+	detail      string
+	description string
+	params      string
+}
+
+// stringMethods lists the built-in string methods with their |parameters|
+var stringMethods = []builtinMethod{
+	{"length", "Get string length", "Returns the number of characters in the string", "||"},
+	{"upper", "Convert to uppercase", "Returns the string in uppercase", "||"},
+	{"lower", "Convert to lowercase", "Returns the string in lowercase", "||"},
+	{"replace", "Replace substring", "Replaces occurrences of a substring with another", "|old, new|"},
+	{"contains", "Check if contains substring", "Returns true if the string contains the substring", "|substring|"},
+	{"camel_case", "Convert to camelCase", "Converts the string to camelCase", "||"},
+	{"snake_case", "Convert to snake_case", "Converts the string to snake_case", "||"},
+	{"pascal_case", "Convert to PascalCase", "Converts the string to PascalCase", "||"},
+	{"kebab_case", "Convert to kebab-case", "Converts the string to kebab-case", "||"},
+	{"match", "Match regex pattern", "Tests if the string matches a regular expression", "|pattern|"},
+	{"split", "Split string", "Splits the string by a delimiter", "|delimiter|"},
+	{"count", "Count occurrences", "Counts occurrences of a character or substring", "|substring|"},
+	{"lpad", "Left pad string", "Pads the string on the left to a specified length", "|length, char|"},
+	{"rpad", "Right pad string", "Pads the string on the right to a specified length", "|length, char|"},
+	{"pad", "Pad string both sides", "Pads the string on both sides to a specified length", "|length, char|"},
+	{"strip", "Trim whitespace", "Removes leading and trailing whitespace", "||"},
+	{"get_file", "Get filename from path", "Extracts the filename from a file path", "||"},
+}
+
 // Helper function to add string methods to completion items
 func addStringMethods(items []protocol.CompletionItem, prefix string) []protocol.CompletionItem {
-	stringMethods := []struct {
-		label       string
-		detail      string
-		description string
-		params      string
-	}{
-		{"length", "Get string length", "Returns the number of characters in the string", "||"},
-		{"upper", "Convert to uppercase", "Returns the string in uppercase", "||"},
-		{"lower", "Convert to lowercase", "Returns the string in lowercase", "||"},
-		{"replace", "Replace substring", "Replaces occurrences of a substring with another", "|old, new|"},
-		{"contains", "Check if contains substring", "Returns true if the string contains the substring", "|substring|"},
-		{"camel_case", "Convert to camelCase", "Converts the string to camelCase", "||"},
-		{"snake_case", "Convert to snake_case", "Converts the string to snake_case", "||"},
-		{"pascal_case", "Convert to PascalCase", "Converts the string to PascalCase", "||"},
-		{"kebab_case", "Convert to kebab-case", "Converts the string to kebab-case", "||"},
-		{"match", "Match regex pattern", "Tests if the string matches a regular expression", "|pattern|"},
-		{"split", "Split string", "Splits the string by a delimiter", "|delimiter|"},
-		{"count", "Count occurrences", "Counts occurrences of a character or substring", "|substring|"},
-		{"lpad", "Left pad string", "Pads the string on the left to a specified length", "|length, char|"},
-		{"rpad", "Right pad string", "Pads the string on the right to a specified length", "|length, char|"},
-		{"pad", "Pad string both sides", "Pads the string on both sides to a specified length", "|length, char|"},
-		{"strip", "Trim whitespace", "Removes leading and trailing whitespace", "||"},
-		{"get_file", "Get filename from path", "Extracts the filename from a file path", "||"},
-	}
-	
-	for _, method := range stringMethods {
-		if prefix == "" || strings.HasPrefix(method.label, prefix) {
-			items = append(items, protocol.CompletionItem{
-				Label:         method.label,
-				Kind:          protocol.CompletionItemKindMethod,
-				Detail:        method.detail,
-				Documentation: method.description,
-				InsertText:    method.label + method.params,
-			})
-		}
-	}
-	return items
+	return addBuiltinMethods(items, stringMethods, prefix)
+}
+
+// arrayMethods lists the built-in array methods with their |parameters|
+var arrayMethods = []builtinMethod{
+	{"length", "Get array length", "Returns the number of elements in the array", "||"},
+	{"push", "Add element", "Adds an element to the end of the array", "|element|"},
+	{"pop", "Remove last element", "Removes and returns the last element", "||"},
+	{"sort", "Sort array", "Sorts the array in place", "||"},
+	{"reverse", "Reverse array", "Reverses the array in place", "||"},
+	{"contains", "Check if contains", "Returns true if array contains element", "|element|"},
+	{"find", "Find element", "Returns index of element or -1", "|element|"},
+	{"filter", "Filter array", "Returns new array with elements matching condition", "|condition|"},
+	{"map", "Map array", "Returns new array with transformed elements", "|transform|"},
+	{"join", "Join to string", "Joins array elements into a string", "|separator|"},
+	{"slice", "Get subarray", "Returns a portion of the array", "|start, end|"},
 }
 
 // Helper function to add array methods to completion items
 func addArrayMethods(items []protocol.CompletionItem, prefix string) []protocol.CompletionItem {
-	arrayMethods := []struct {
-		label       string
-		detail      string
-		description string
-		params      string
-	}{
-		{"length", "Get array length", "Returns the number of elements in the array", "||"},
-		{"push", "Add element", "Adds an element to the end of the array", "|element|"},
-		{"pop", "Remove last element", "Removes and returns the last element", "||"},
-		{"sort", "Sort array", "Sorts the array in place", "||"},
-		{"reverse", "Reverse array", "Reverses the array in place", "||"},
-		{"contains", "Check if contains", "Returns true if array contains element", "|element|"},
-		{"find", "Find element", "Returns index of element or -1", "|element|"},
-		{"filter", "Filter array", "Returns new array with elements matching condition", "|condition|"},
-		{"map", "Map array", "Returns new array with transformed elements", "|transform|"},
-		{"join", "Join to string", "Joins array elements into a string", "|separator|"},
-		{"slice", "Get subarray", "Returns a portion of the array", "|start, end|"},
-	}
-	
-	for _, method := range arrayMethods {
-		if prefix == "" || strings.HasPrefix(method.label, prefix) {
-			items = append(items, protocol.CompletionItem{
-				Label:         method.label,
-				Kind:          protocol.CompletionItemKindMethod,
-				Detail:        method.detail,
-				Documentation: method.description,
-				InsertText:    method.label + method.params,
-			})
-		}
-	}
-	return items
+	return addBuiltinMethods(items, arrayMethods, prefix)
+}
+
+// dictMethods lists the built-in dict methods with their |parameters|
+var dictMethods = []builtinMethod{
+	{"size", "Get dictionary size", "Returns the number of key-value pairs in the dictionary", "||"},
+	{"clear", "Clear all entries", "Removes all entries from the dictionary", "||"},
+	{"has", "Check if key exists", "Returns true if the key exists in the dictionary", "|key|"},
+	{"has_all", "Check if all keys exist", "Returns true if all keys in the array exist", "|keys_array|"},
+	{"keys", "Get all keys", "Returns an array of all dictionary keys", "||"},
+	{"values", "Get all values", "Returns an array of all dictionary values", "||"},
+	{"sort", "Sort by keys", "Returns a new dictionary sorted by keys", "||"},
+	{"stable_sort", "Stable sort by keys", "Returns a new dictionary with stable sort by keys", "||"},
+	{"merge", "Merge dictionaries", "Merges another dictionary into this one", "|other_dict|"},
 }
 
 // Helper function to add dict methods to completion items
 func addDictMethods(items []protocol.CompletionItem, prefix string) []protocol.CompletionItem {
-	dictMethods := []struct {
-		label       string
-		detail      string
-		description string
-		params      string
-	}{
-		{"size", "Get dictionary size", "Returns the number of key-value pairs in the dictionary", "||"},
-		{"clear", "Clear all entries", "Removes all entries from the dictionary", "||"},
-		{"has", "Check if key exists", "Returns true if the key exists in the dictionary", "|key|"},
-		{"has_all", "Check if all keys exist", "Returns true if all keys in the array exist", "|keys_array|"},
-		{"keys", "Get all keys", "Returns an array of all dictionary keys", "||"},
-		{"values", "Get all values", "Returns an array of all dictionary values", "||"},
-		{"sort", "Sort by keys", "Returns a new dictionary sorted by keys", "||"},
-		{"stable_sort", "Stable sort by keys", "Returns a new dictionary with stable sort by keys", "||"},
-		{"merge", "Merge dictionaries", "Merges another dictionary into this one", "|other_dict|"},
-	}
-	
-	for _, method := range dictMethods {
+	return addBuiltinMethods(items, dictMethods, prefix)
+}
+
+func addBuiltinMethods(items []protocol.CompletionItem, methods []builtinMethod, prefix string) []protocol.CompletionItem {
+	for _, method := range methods {
 		if prefix == "" || strings.HasPrefix(method.label, prefix) {
 			items = append(items, protocol.CompletionItem{
 				Label:         method.label,
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// settings are the options read from project files, initializationOptions
+// and the client's workspace configuration. Options that are left out keep
+// their default.
+type settings struct {
+	InlayHints   inlayHintSettings  `json:"inlayHints"`
+	Lint         lintSettings       `json:"lint"`
+	Limits       limitSettings      `json:"limits"`
+	Formatting   formattingSettings `json:"formatting"`
+	IncludePaths []string           `json:"includePaths"` // Extra directories indexed with the workspace
+}
+
+// inlayHintSettings toggles each category of inlay hint
+type inlayHintSettings struct {
+	VariableTypes     bool `json:"variableTypes"`
+	ParameterNames    bool `json:"parameterNames"`
+	LoopVariableTypes bool `json:"loopVariableTypes"`
+}
+
+// limitSettings bound the work done on large or complex documents
+type limitSettings struct {
+	MaxDocumentSize      int      `json:"maxDocumentSize"`      // Bytes; larger documents aren't parsed
+	MaxHoverDocumentSize int      `json:"maxHoverDocumentSize"` // Bytes; larger documents get no hovers
+	ParseTimeout         duration `json:"parseTimeout"`
+	MemoryLimitMB        int64    `json:"memoryLimitMB"`
+	MaxOutlineSymbols    int      `json:"maxOutlineSymbols"`
+}
+
+// formattingSettings override the editor's formatting options. An empty
+// IndentStyle and a zero TabSize leave the editor's choice.
+type formattingSettings struct {
+	IndentStyle   string `json:"indentStyle"` // "spaces" or "tabs"
+	TabSize       int    `json:"tabSize"`
+	MaxBlankLines int    `json:"maxBlankLines"`
+}
+
+// duration is a time.Duration written as a string such as "5s"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\"")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func defaultSettings() settings {
+	return settings{
+		InlayHints: inlayHintSettings{
+			VariableTypes:     true,
+			ParameterNames:    true,
+			LoopVariableTypes: true,
+		},
+		Lint: lintSettings{Rules: map[string]ruleSetting{}},
+		Limits: limitSettings{
+			MaxDocumentSize:      defaultMaxDocumentSize,
+			MaxHoverDocumentSize: defaultMaxHoverDocumentSize,
+			ParseTimeout:         duration(defaultParseTimeout),
+			MemoryLimitMB:        defaultMemoryLimitMB,
+			MaxOutlineSymbols:    defaultMaxOutlineSymbols,
+		},
+		Formatting: formattingSettings{
+			MaxBlankLines: defaultMaxBlankLines,
+		},
+	}
+}
+
+// normalize replaces out of range values with their defaults
+func (s *settings) normalize() {
+	defaults := defaultSettings()
+
+	if s.Limits.MaxDocumentSize <= 0 {
+		s.Limits.MaxDocumentSize = defaults.Limits.MaxDocumentSize
+	}
+	if s.Limits.MaxHoverDocumentSize <= 0 {
+		s.Limits.MaxHoverDocumentSize = defaults.Limits.MaxHoverDocumentSize
+	}
+	if s.Limits.ParseTimeout <= 0 {
+		s.Limits.ParseTimeout = defaults.Limits.ParseTimeout
+	}
+	if s.Limits.MemoryLimitMB <= 0 {
+		s.Limits.MemoryLimitMB = defaults.Limits.MemoryLimitMB
+	}
+	if s.Limits.MaxOutlineSymbols <= 0 {
+		s.Limits.MaxOutlineSymbols = defaults.Limits.MaxOutlineSymbols
+	}
+
+	if s.Formatting.IndentStyle != "" && s.Formatting.IndentStyle != "spaces" && s.Formatting.IndentStyle != "tabs" {
+		debugLog.Printf("Unknown indent style %q, using the editor's", s.Formatting.IndentStyle)
+		s.Formatting.IndentStyle = ""
+	}
+	if s.Formatting.TabSize < 0 {
+		s.Formatting.TabSize = 0
+	}
+	if s.Formatting.MaxBlankLines < 0 {
+		s.Formatting.MaxBlankLines = defaults.Formatting.MaxBlankLines
+	}
+}
+
+// apply overrides the editor's formatting options
+func (f formattingSettings) apply(options protocol.FormattingOptions) protocol.FormattingOptions {
+	switch f.IndentStyle {
+	case "spaces":
+		options.InsertSpaces = true
+	case "tabs":
+		options.InsertSpaces = false
+	}
+	if f.TabSize > 0 {
+		options.TabSize = uint32(f.TabSize)
+	}
+	return options
+}
+
+// rawSettings marshals settings received from the client. Missing settings
+// give nil.
+func rawSettings(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		debugLog.Printf("Invalid settings: %v", err)
+		return nil
+	}
+	return data
+}
+
+// mergeSettings applies raw options on top of base. The options may be
+// given directly or nested under an "ahoy" section, as editors send them
+// for workspace/didChangeConfiguration.
+func mergeSettings(base settings, data json.RawMessage) (settings, error) {
+	var section struct {
+		Ahoy json.RawMessage `json:"ahoy"`
+	}
+	if err := json.Unmarshal(data, &section); err == nil && len(section.Ahoy) > 0 {
+		data = section.Ahoy
+	}
+
+	// Maps and slices are decoded into copies, as readers may hold the
+	// current ones
+	updated := base
+	updated.Lint = base.Lint.clone()
+	updated.IncludePaths = append([]string(nil), base.IncludePaths...)
+	if err := json.Unmarshal(data, &updated); err != nil {
+		return base, err
+	}
+	return updated, nil
+}
+
+// updateSettings recomputes the effective settings: the defaults with the
+// project files, initializationOptions and the client's workspace
+// configuration applied in that order. The limits and include paths are
+// then handed to the runtime and the workspace index.
+func (s *Server) updateSettings(folders []string) {
+	s.mu.Lock()
+	layers := append([]json.RawMessage{}, s.projectSettings...)
+	layers = append(layers, s.initOptions, s.clientSettings)
+
+	resolved := defaultSettings()
+	for _, layer := range layers {
+		if len(layer) == 0 {
+			continue
+		}
+		merged, err := mergeSettings(resolved, layer)
+		if err != nil {
+			debugLog.Printf("Invalid settings: %v", err)
+			continue
+		}
+		resolved = merged
+	}
+	resolved.normalize()
+	s.settings = resolved
+	s.settingsVersion++
+	s.mu.Unlock()
+
+	setMemoryLimit(s, resolved.Limits.MemoryLimitMB)
+	s.workspace.configure(resolved.Limits, includeDirs(resolved.IncludePaths, folders))
+}
+
+// memoryLimits holds the memory limit each connected Server is configured
+// with. The runtime's limit is process-wide, so clients sharing a listener
+// get the largest of them rather than whichever was configured last.
+var memoryLimits = struct {
+	sync.Mutex
+	byServer map[*Server]int64
+}{byServer: make(map[*Server]int64)}
+
+// setMemoryLimit records the limit of s in megabytes, or forgets s if
+// limitMB is 0, and applies the largest limit left
+func setMemoryLimit(s *Server, limitMB int64) {
+	memoryLimits.Lock()
+	defer memoryLimits.Unlock()
+
+	if limitMB > 0 {
+		memoryLimits.byServer[s] = limitMB
+	} else {
+		delete(memoryLimits.byServer, s)
+	}
+
+	largest := int64(0)
+	for _, limit := range memoryLimits.byServer {
+		largest = max(largest, limit)
+	}
+	if largest == 0 {
+		largest = defaultMemoryLimitMB
+	}
+	debug.SetMemoryLimit(largest * 1024 * 1024)
+}
+
+// includeDirs resolves include paths. Relative paths are taken relative to
+// each workspace folder.
+func includeDirs(paths, folders []string) []string {
+	dirs := []string{}
+	for _, path := range paths {
+		if filepath.IsAbs(path) {
+			dirs = append(dirs, filepath.Clean(path))
+			continue
+		}
+		for _, folder := range folders {
+			dirs = append(dirs, filepath.Join(folder, path))
+		}
+	}
+	return dirs
+}
+
+func (s *Server) getSettings() settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings
+}
+
+// getVersionedSettings returns the settings along with a version that
+// changes whenever they are resolved again
+func (s *Server) getVersionedSettings() (settings, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings, s.settingsVersion
+}
+
+// fetchConfiguration pulls the "ahoy" section of the client's configuration
+// with workspace/configuration. It must not be called from a handler, which
+// has to return before the client's reply can be read.
+func (s *Server) fetchConfiguration() {
+	params := protocol.ConfigurationParams{
+		Items: []protocol.ConfigurationItem{{Section: "ahoy"}},
+	}
+
+	var result []json.RawMessage
+	if _, err := s.conn.Call(context.Background(), protocol.MethodWorkspaceConfiguration, params, &result); err != nil {
+		debugLog.Printf("Fetching configuration failed: %v", err)
+		return
+	}
+	if len(result) == 0 || string(result[0]) == "null" {
+		return
+	}
+
+	s.mu.Lock()
+	s.clientSettings = result[0]
+	s.mu.Unlock()
+
+	s.updateSettings(s.workspace.folders())
+	s.refreshDiagnostics(context.Background())
+}
+
+func (s *Server) handleDidChangeConfiguration(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.DidChangeConfigurationParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	// Clients that support workspace/configuration often send no settings
+	// with the notification, so the section is pulled again and applied
+	// when it arrives
+	s.mu.Lock()
+	pull := s.pullConfiguration
+	if raw := rawSettings(params.Settings); raw != nil && !pull {
+		s.clientSettings = raw
+	}
+	s.mu.Unlock()
+	if pull {
+		go s.fetchConfiguration()
+		return reply(ctx, nil, nil)
+	}
+
+	s.updateSettings(s.workspace.folders())
+	s.refreshDiagnostics(ctx)
+	return reply(ctx, nil, nil)
+}
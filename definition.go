@@ -20,30 +20,21 @@ func (s *Server) handleDefinition(ctx context.Context, reply jsonrpc2.Replier, r
 	}
 
 	// Get the word at the cursor position
-	word := getWordAtPosition(doc, int(params.Position.Line), int(params.Position.Character))
+	word := getWordAtPosition(doc, int(params.Position.Line), sourceColumn(doc.Lines, params.Position))
 	if word == "" {
 		return reply(ctx, nil, nil)
 	}
 
-	// Look up the symbol in the symbol table
-	symbol := doc.SymbolTable.Lookup(word)
+	// Look up the symbol in the scope containing the cursor
+	symbol := doc.SymbolTable.LookupAt(word, int(params.Position.Line)+1, sourceColumn(doc.Lines, params.Position))
 	if symbol == nil {
 		return reply(ctx, nil, nil)
 	}
 
 	// Return the definition location
 	location := protocol.Location{
-		URI: params.TextDocument.URI,
-		Range: protocol.Range{
-			Start: protocol.Position{
-				Line:      uint32(symbol.Line - 1),
-				Character: uint32(symbol.Column),
-			},
-			End: protocol.Position{
-				Line:      uint32(symbol.Line - 1),
-				Character: uint32(symbol.Column + len(symbol.Name)),
-			},
-		},
+		URI:   params.TextDocument.URI,
+		Range: symbolRange(doc.Lines, symbol),
 	}
 
 	return reply(ctx, location, nil)
@@ -10,59 +10,27 @@ import (
 )
 
 func (s *Server) publishDiagnostics(ctx context.Context, doc *Document) {
-	diagnostics := []protocol.Diagnostic{}
-
-	// Check for program declaration position
-	if doc.AST != nil {
-		programDiag := checkProgramDeclarationPosition(doc)
-		if programDiag != nil {
-			diagnostics = append(diagnostics, *programDiag)
-		}
-
-		// Check for const reassignment and variable/const name collisions
-		if doc.SymbolTable != nil {
-			constDiags := checkConstReassignment(doc)
-			diagnostics = append(diagnostics, constDiags...)
-
-			// Check const method calls
-			constMethodDiags := checkConstMethodCalls(doc)
-			diagnostics = append(diagnostics, constMethodDiags...)
-
-			// Check invalid method calls
-			invalidMethodDiags := checkInvalidMethodCalls(doc)
-			diagnostics = append(diagnostics, invalidMethodDiags...)
-
-			// Check return type violations
-			returnDiags := checkReturnTypeViolations(doc)
-			diagnostics = append(diagnostics, returnDiags...)
-
-			// Check enum duplicates
-			enumDiags := checkEnumDuplicates(doc)
-			diagnostics = append(diagnostics, enumDiags...)
-
-			// Check enum name duplicates
-			enumNameDiags := checkEnumNameDuplicates(doc)
-			diagnostics = append(diagnostics, enumNameDiags...)
-
-			// Check undefined function calls
-			undefinedFuncDiags := checkUndefinedFunctions(doc)
-			diagnostics = append(diagnostics, undefinedFuncDiags...)
-
-			// Check undeclared identifiers (variables, constants, enums)
-			undeclaredDiags := checkUndeclaredIdentifiers(doc)
-			diagnostics = append(diagnostics, undeclaredDiags...)
+	// Send diagnostics to the editor
+	params := protocol.PublishDiagnosticsParams{
+		URI:         doc.URI,
+		Diagnostics: computeDiagnostics(doc, s.getSettings().Lint),
+	}
 
-			// Check function call argument counts
-			argCountDiags := checkFunctionCallArgumentCounts(doc)
-			diagnostics = append(diagnostics, argCountDiags...)
+	// Notify the client (no reply expected)
+	s.conn.Notify(ctx, protocol.MethodTextDocumentPublishDiagnostics, params)
+}
 
-			// Check function call argument types
-			argTypeDiags := checkFunctionCallArgumentTypes(doc)
-			diagnostics = append(diagnostics, argTypeDiags...)
+// computeDiagnostics runs the enabled checks on doc and converts its parse
+// errors. The lint settings decide which rules are reported and how severely,
+// and suppression comments in the document drop individual diagnostics.
+func computeDiagnostics(doc *Document, lint lintSettings) []protocol.Diagnostic {
+	diagnostics := []protocol.Diagnostic{}
 
-			// Check variable/constant type mismatches
-			typeMismatchDiags := checkTypeMismatches(doc)
-			diagnostics = append(diagnostics, typeMismatchDiags...)
+	if doc.AST != nil && doc.SymbolTable != nil {
+		for _, check := range lintChecks {
+			if lint.enabled(check.codes) {
+				diagnostics = append(diagnostics, lint.applyRules(check.run(doc))...)
+			}
 		}
 	}
 
@@ -81,16 +49,7 @@ func (s *Server) publishDiagnostics(ctx context.Context, doc *Document) {
 		}
 
 		diagnostic := protocol.Diagnostic{
-			Range: protocol.Range{
-				Start: protocol.Position{
-					Line:      uint32(err.Line - 1), // LSP is 0-based, parser is 1-based
-					Character: uint32(startCol),
-				},
-				End: protocol.Position{
-					Line:      uint32(err.Line - 1),
-					Character: uint32(endCol),
-				},
-			},
+			Range:    lspRange(doc.Lines, err.Line, startCol, err.Line, endCol),
 			Severity: severity,
 			Source:   "ahoy",
 			Message:  err.Message,
@@ -99,14 +58,7 @@ func (s *Server) publishDiagnostics(ctx context.Context, doc *Document) {
 		diagnostics = append(diagnostics, diagnostic)
 	}
 
-	// Send diagnostics to the editor
-	params := protocol.PublishDiagnosticsParams{
-		URI:         doc.URI,
-		Diagnostics: diagnostics,
-	}
-
-	// Notify the client (no reply expected)
-	s.conn.Notify(ctx, protocol.MethodTextDocumentPublishDiagnostics, params)
+	return applySuppressions(doc, diagnostics, lint)
 }
 
 // checkProgramDeclarationPosition checks if program declaration is on the first line
@@ -151,27 +103,8 @@ func checkProgramDeclarationPosition(doc *Document) *protocol.Diagnostic {
 
 	// If program node is not on the first non-empty line, create diagnostic
 	if programNode.Line > firstNonEmptyLine+1 {
-		// Get the line text to calculate end column
-		lineText := ""
-		if programNode.Line > 0 && programNode.Line <= len(doc.Lines) {
-			lineText = doc.Lines[programNode.Line-1]
-		}
-		endChar := uint32(len(lineText))
-		if endChar == 0 {
-			endChar = 20 // Default if we can't get line text
-		}
-
 		return &protocol.Diagnostic{
-			Range: protocol.Range{
-				Start: protocol.Position{
-					Line:      uint32(programNode.Line - 1),
-					Character: 0,
-				},
-				End: protocol.Position{
-					Line:      uint32(programNode.Line - 1),
-					Character: endChar,
-				},
-			},
+			Range:    nodeRange(doc, programNode),
 			Severity: protocol.DiagnosticSeverityError,
 			Source:   "ahoy",
 			Message:  "Program declaration must be on the first line of the file",
@@ -182,6 +115,55 @@ func checkProgramDeclarationPosition(doc *Document) *protocol.Diagnostic {
 	return nil
 }
 
+// nodeRange is the source range of node, or the code on its line when the
+// node has no span
+func nodeRange(doc *Document, node *ahoy.ASTNode) protocol.Range {
+	if span, ok := doc.Spans[node]; ok {
+		return span.toRange(doc.Lines)
+	}
+	line := node.Line
+	if line <= 0 {
+		line = 1
+	}
+	return (&spanBuilder{lines: doc.Lines}).lineSpan(line, line).toRange(doc.Lines)
+}
+
+// nodeNameRange is the range of name where node starts, such as the name a
+// declaration introduces or the callee of a call
+func nodeNameRange(doc *Document, node *ahoy.ASTNode, name string) protocol.Range {
+	line, column := node.Line, 0
+	if span, ok := doc.Spans[node]; ok {
+		line, column = span.StartLine, span.StartColumn
+	}
+	if line <= 0 {
+		line = 1
+	}
+	return nameRangeOnLine(doc, line, column, name)
+}
+
+// methodNameRange is the range of the method name in a method call, which
+// follows the call's target
+func methodNameRange(doc *Document, call *ahoy.ASTNode, name string) protocol.Range {
+	if len(call.Children) > 0 && call.Children[0] != nil {
+		if span, ok := doc.Spans[call.Children[0]]; ok {
+			return nameRangeOnLine(doc, span.EndLine, span.EndColumn, name)
+		}
+	}
+	return nodeNameRange(doc, call, name)
+}
+
+// lookupAtNode resolves name in the scope where node appears
+func lookupAtNode(doc *Document, name string, node *ahoy.ASTNode) *Symbol {
+	line, column := node.Line, 0
+	if span, ok := doc.Spans[node]; ok {
+		line, column = span.StartLine, span.StartColumn
+	} else if line > 0 && line <= len(doc.Lines) {
+		text := doc.Lines[line-1]
+		column = len(text) - len(strings.TrimLeft(text, " \t"))
+	}
+	return doc.SymbolTable.LookupAt(name, line, column)
+}
+
 // checkConstReassignment checks for const reassignment and variable/const name collisions
 func checkConstReassignment(doc *Document) []protocol.Diagnostic {
 	diagnostics := []protocol.Diagnostic{}
@@ -203,29 +185,11 @@ func checkConstReassignment(doc *Document) []protocol.Diagnostic {
 			varName := node.Value
 			if varName != "" {
 				// Look up the symbol
-				sym := doc.SymbolTable.GlobalScope.Lookup(varName)
+				sym := lookupAtNode(doc, varName, node)
 				if sym != nil && sym.Kind == SymbolKindConstant {
 					// Error: trying to reassign a constant
-					lineText := ""
-					if node.Line > 0 && node.Line <= len(doc.Lines) {
-						lineText = doc.Lines[node.Line-1]
-					}
-					endChar := uint32(len(lineText))
-					if endChar == 0 {
-						endChar = uint32(len(varName) + 10)
-					}
-
 					diagnostic := protocol.Diagnostic{
-						Range: protocol.Range{
-							Start: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: 0,
-							},
-							End: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: endChar,
-							},
-						},
+						Range:    nodeNameRange(doc, node, varName),
 						Severity: protocol.DiagnosticSeverityError,
 						Source:   "ahoy",
 						Message:  "Cannot reassign constant '" + varName + "'",
@@ -240,29 +204,11 @@ func checkConstReassignment(doc *Document) []protocol.Diagnostic {
 			varName := node.Value
 			if varName != "" {
 				// Look up the symbol in parent scopes
-				sym := doc.SymbolTable.GlobalScope.Lookup(varName)
+				sym := lookupAtNode(doc, varName, node)
 				if sym != nil && sym.Kind == SymbolKindConstant && sym.Line < node.Line {
 					// Error: variable name already used by constant
-					lineText := ""
-					if node.Line > 0 && node.Line <= len(doc.Lines) {
-						lineText = doc.Lines[node.Line-1]
-					}
-					endChar := uint32(len(lineText))
-					if endChar == 0 {
-						endChar = uint32(len(varName) + 10)
-					}
-
 					diagnostic := protocol.Diagnostic{
-						Range: protocol.Range{
-							Start: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: 0,
-							},
-							End: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: endChar,
-							},
-						},
+						Range:    nodeNameRange(doc, node, varName),
 						Severity: protocol.DiagnosticSeverityError,
 						Source:   "ahoy",
 						Message:  "Cannot declare variable '" + varName + "' - already declared as constant",
@@ -277,29 +223,11 @@ func checkConstReassignment(doc *Document) []protocol.Diagnostic {
 			constName := node.Value
 			if constName != "" {
 				// Look for previous declarations
-				sym := doc.SymbolTable.GlobalScope.Lookup(constName)
+				sym := lookupAtNode(doc, constName, node)
 				if sym != nil && sym.Kind == SymbolKindConstant && sym.Line < node.Line {
 					// Error: constant already declared
-					lineText := ""
-					if node.Line > 0 && node.Line <= len(doc.Lines) {
-						lineText = doc.Lines[node.Line-1]
-					}
-					endChar := uint32(len(lineText))
-					if endChar == 0 {
-						endChar = uint32(len(constName) + 10)
-					}
-
 					diagnostic := protocol.Diagnostic{
-						Range: protocol.Range{
-							Start: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: 0,
-							},
-							End: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: endChar,
-							},
-						},
+						Range:    nodeNameRange(doc, node, constName),
 						Severity: protocol.DiagnosticSeverityError,
 						Source:   "ahoy",
 						Message:  "Cannot redeclare constant '" + constName + "'",
@@ -320,6 +248,59 @@ func checkConstReassignment(doc *Document) []protocol.Diagnostic {
 	return diagnostics
 }
 
+// checkFunctionNameCollisions reports variables, constants, structs and enums
+// declared with the name of a function in the same scope. The symbol table
+// keeps the function, so the other declaration is never resolved.
+func checkFunctionNameCollisions(doc *Document) []protocol.Diagnostic {
+	diagnostics := []protocol.Diagnostic{}
+
+	if doc.AST == nil || doc.SymbolTable == nil {
+		return diagnostics
+	}
+
+	var checkNode func(*ahoy.ASTNode, int)
+	checkNode = func(node *ahoy.ASTNode, depth int) {
+		if node == nil || depth > 1000 {
+			return
+		}
+
+		switch node.Type {
+		case ahoy.NODE_VARIABLE_DECLARATION, ahoy.NODE_ASSIGNMENT, ahoy.NODE_CONSTANT_DECLARATION,
+			ahoy.NODE_STRUCT_DECLARATION, ahoy.NODE_ENUM_DECLARATION:
+			name := node.Value
+			if name == "" {
+				break
+			}
+
+			line, column := node.Line, 0
+			if span, ok := doc.Spans[node]; ok {
+				line, column = span.StartLine, span.StartColumn
+			}
+			scope := doc.SymbolTable.ScopeAt(line, column)
+			if scope == nil {
+				break
+			}
+
+			if sym := scope.LookupLocal(name); sym != nil && sym.Kind == SymbolKindFunction {
+				diagnostics = append(diagnostics, protocol.Diagnostic{
+					Range:    nodeNameRange(doc, node, name),
+					Severity: protocol.DiagnosticSeverityError,
+					Source:   "ahoy",
+					Message:  "Cannot declare '" + name + "' - already declared as function",
+					Code:     "function-name-collision",
+				})
+			}
+		}
+
+		for _, child := range node.Children {
+			checkNode(child, depth+1)
+		}
+	}
+
+	checkNode(doc.AST, 0)
+	return diagnostics
+}
+
 // checkConstMethodCalls checks for method calls on constants
 func checkConstMethodCalls(doc *Document) []protocol.Diagnostic {
 	diagnostics := []protocol.Diagnostic{}
@@ -344,29 +325,11 @@ func checkConstMethodCalls(doc *Document) []protocol.Diagnostic {
 				if target != nil && target.Type == ahoy.NODE_IDENTIFIER {
 					varName := target.Value
 					// Look up the symbol
-					sym := doc.SymbolTable.GlobalScope.Lookup(varName)
+					sym := lookupAtNode(doc, varName, target)
 					if sym != nil && sym.Kind == SymbolKindConstant {
 						// Error: trying to call method on constant
-						lineText := ""
-						if node.Line > 0 && node.Line <= len(doc.Lines) {
-							lineText = doc.Lines[node.Line-1]
-						}
-						endChar := uint32(len(lineText))
-						if endChar == 0 {
-							endChar = uint32(len(varName) + 20)
-						}
-
 						diagnostic := protocol.Diagnostic{
-							Range: protocol.Range{
-								Start: protocol.Position{
-									Line:      uint32(node.Line - 1),
-									Character: 0,
-								},
-								End: protocol.Position{
-									Line:      uint32(node.Line - 1),
-									Character: endChar,
-								},
-							},
+							Range:    nodeRange(doc, target),
 							Severity: protocol.DiagnosticSeverityError,
 							Source:   "ahoy",
 							Message:  "Cannot call methods on constant '" + varName + "'",
@@ -441,7 +404,7 @@ func checkInvalidMethodCalls(doc *Document) []protocol.Diagnostic {
 				if target != nil {
 					if target.Type == ahoy.NODE_IDENTIFIER {
 						// Look up the symbol to get its type
-						sym := doc.SymbolTable.GlobalScope.Lookup(target.Value)
+						sym := lookupAtNode(doc, target.Value, target)
 						if sym != nil {
 							targetType = sym.Type
 						}
@@ -507,26 +470,8 @@ func checkInvalidMethodCalls(doc *Document) []protocol.Diagnostic {
 						message += ", did you mean '" + bestMatch + "'?"
 					}
 
-					lineText := ""
-					if node.Line > 0 && node.Line <= len(doc.Lines) {
-						lineText = doc.Lines[node.Line-1]
-					}
-					endChar := uint32(len(lineText))
-					if endChar == 0 {
-						endChar = uint32(len(methodName) + 20)
-					}
-
 					diagnostic := protocol.Diagnostic{
-						Range: protocol.Range{
-							Start: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: 0,
-							},
-							End: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: endChar,
-							},
-						},
+						Range:    methodNameRange(doc, node, methodName),
 						Severity: protocol.DiagnosticSeverityError,
 						Source:   "ahoy",
 						Message:  message,
@@ -586,26 +531,8 @@ func checkReturnTypeViolations(doc *Document) []protocol.Diagnostic {
 							// Check if void function returns a value
 							returnedType := inferReturnType(n.Children[0])
 
-							lineText := ""
-							if n.Line > 0 && n.Line <= len(doc.Lines) {
-								lineText = doc.Lines[n.Line-1]
-							}
-							endChar := uint32(len(lineText))
-							if endChar == 0 {
-								endChar = 30
-							}
-
 							diagnostic := protocol.Diagnostic{
-								Range: protocol.Range{
-									Start: protocol.Position{
-										Line:      uint32(n.Line - 1),
-										Character: 0,
-									},
-									End: protocol.Position{
-										Line:      uint32(n.Line - 1),
-										Character: endChar,
-									},
-								},
+								Range:    nodeRange(doc, n.Children[0]),
 								Severity: protocol.DiagnosticSeverityError,
 								Source:   "ahoy",
 								Message:  "Expected void, got return type " + returnedType,
@@ -627,26 +554,8 @@ func checkReturnTypeViolations(doc *Document) []protocol.Diagnostic {
 							}
 
 							if !matches && returnedType != "unknown" {
-								lineText := ""
-								if n.Line > 0 && n.Line <= len(doc.Lines) {
-									lineText = doc.Lines[n.Line-1]
-								}
-								endChar := uint32(len(lineText))
-								if endChar == 0 {
-									endChar = 30
-								}
-
 								diagnostic := protocol.Diagnostic{
-									Range: protocol.Range{
-										Start: protocol.Position{
-											Line:      uint32(n.Line - 1),
-											Character: 0,
-										},
-										End: protocol.Position{
-											Line:      uint32(n.Line - 1),
-											Character: endChar,
-										},
-									},
+									Range:    nodeRange(doc, n.Children[0]),
 									Severity: protocol.DiagnosticSeverityError,
 									Source:   "ahoy",
 									Message:  "Expected return type " + returnType + ", got " + returnedType,
@@ -667,26 +576,8 @@ func checkReturnTypeViolations(doc *Document) []protocol.Diagnostic {
 
 			// Check if non-void, non-infer function has return statement
 			if returnType != "" && returnType != "void" && returnType != "infer" && !hasReturn {
-				lineText := ""
-				if node.Line > 0 && node.Line <= len(doc.Lines) {
-					lineText = doc.Lines[node.Line-1]
-				}
-				endChar := uint32(len(lineText))
-				if endChar == 0 {
-					endChar = 30
-				}
-
 				diagnostic := protocol.Diagnostic{
-					Range: protocol.Range{
-						Start: protocol.Position{
-							Line:      uint32(node.Line - 1),
-							Character: 0,
-						},
-						End: protocol.Position{
-							Line:      uint32(node.Line - 1),
-							Character: endChar,
-						},
-					},
+					Range:    nodeNameRange(doc, node, node.Value),
 					Severity: protocol.DiagnosticSeverityError,
 					Source:   "ahoy",
 					Message:  "Function with return type " + returnType + " must return a value",
@@ -750,43 +641,25 @@ func checkEnumDuplicates(doc *Document) []protocol.Diagnostic {
 		}
 
 		if node.Type == ahoy.NODE_ENUM_DECLARATION {
-			// Track member names and their lines
-			memberMap := make(map[string][]int)
+			// Track member names and their declarations
+			memberMap := make(map[string][]*ahoy.ASTNode)
 
 			// Collect all member names
 			for _, child := range node.Children {
 				if child.Type == ahoy.NODE_IDENTIFIER {
 					memberName := child.Value
-					memberMap[memberName] = append(memberMap[memberName], child.Line)
+					memberMap[memberName] = append(memberMap[memberName], child)
 				}
 			}
 
 			// Check for duplicates
-			for memberName, lines := range memberMap {
-				if len(lines) > 1 {
+			for memberName, members := range memberMap {
+				if len(members) > 1 {
 					// Report error for each duplicate occurrence (except the first)
-					for i := 1; i < len(lines); i++ {
-						line := lines[i]
-						lineText := ""
-						if line > 0 && line <= len(doc.Lines) {
-							lineText = doc.Lines[line-1]
-						}
-						endChar := uint32(len(lineText))
-						if endChar == 0 {
-							endChar = uint32(len(memberName) + 10)
-						}
-
+					for i := 1; i < len(members); i++ {
+						member := members[i]
 						diagnostic := protocol.Diagnostic{
-							Range: protocol.Range{
-								Start: protocol.Position{
-									Line:      uint32(line - 1),
-									Character: 0,
-								},
-								End: protocol.Position{
-									Line:      uint32(line - 1),
-									Character: endChar,
-								},
-							},
+							Range:    nodeNameRange(doc, member, memberName),
 							Severity: protocol.DiagnosticSeverityError,
 							Source:   "ahoy",
 							Message:  "Duplicate enum member '" + memberName + "'",
@@ -816,8 +689,8 @@ func checkEnumNameDuplicates(doc *Document) []protocol.Diagnostic {
 		return diagnostics
 	}
 
-	// Track enum names and their lines
-	enumMap := make(map[string][]int)
+	// Track enum names and their declarations
+	enumMap := make(map[string][]*ahoy.ASTNode)
 
 	// Walk the AST looking for enum declarations
 	var checkNode func(*ahoy.ASTNode)
@@ -829,7 +702,7 @@ func checkEnumNameDuplicates(doc *Document) []protocol.Diagnostic {
 		if node.Type == ahoy.NODE_ENUM_DECLARATION {
 			enumName := node.Value
 			if enumName != "" {
-				enumMap[enumName] = append(enumMap[enumName], node.Line)
+				enumMap[enumName] = append(enumMap[enumName], node)
 			}
 		}
 
@@ -842,31 +715,13 @@ func checkEnumNameDuplicates(doc *Document) []protocol.Diagnostic {
 	checkNode(doc.AST)
 
 	// Check for duplicate enum names
-	for enumName, lines := range enumMap {
-		if len(lines) > 1 {
+	for enumName, enums := range enumMap {
+		if len(enums) > 1 {
 			// Report error for each duplicate occurrence (except the first)
-			for i := 1; i < len(lines); i++ {
-				line := lines[i]
-				lineText := ""
-				if line > 0 && line <= len(doc.Lines) {
-					lineText = doc.Lines[line-1]
-				}
-				endChar := uint32(len(lineText))
-				if endChar == 0 {
-					endChar = uint32(len(enumName) + 10)
-				}
-
+			for i := 1; i < len(enums); i++ {
+				enum := enums[i]
 				diagnostic := protocol.Diagnostic{
-					Range: protocol.Range{
-						Start: protocol.Position{
-							Line:      uint32(line - 1),
-							Character: 0,
-						},
-						End: protocol.Position{
-							Line:      uint32(line - 1),
-							Character: endChar,
-						},
-					},
+					Range:    keywordNameRange(doc, enum.Line, "enum", enumName),
 					Severity: protocol.DiagnosticSeverityError,
 					Source:   "ahoy",
 					Message:  "Enum '" + enumName + "' declared twice",
@@ -994,20 +849,11 @@ func checkUndefinedFunctions(doc *Document) []protocol.Diagnostic {
 
 			// Check if function exists (built-in or user-defined)
 			if !isBuiltinFunction(funcName) {
-				sym := doc.SymbolTable.GlobalScope.Lookup(funcName)
+				sym := lookupAtNode(doc, funcName, node)
 				if sym == nil || sym.Kind != SymbolKindFunction {
 					// Function not found - find similar function
 					similarFunc, distance := findSimilarFunction(funcName, availableFuncs)
 
-					lineText := ""
-					if node.Line > 0 && node.Line <= len(doc.Lines) {
-						lineText = doc.Lines[node.Line-1]
-					}
-					endChar := uint32(len(lineText))
-					if endChar == 0 {
-						endChar = uint32(len(funcName) + 10)
-					}
-
 					message := funcName + " func not found"
 
 					// If we found a similar function within reasonable distance, suggest it
@@ -1022,16 +868,7 @@ func checkUndefinedFunctions(doc *Document) []protocol.Diagnostic {
 					}
 
 					diagnostic := protocol.Diagnostic{
-						Range: protocol.Range{
-							Start: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: 0,
-							},
-							End: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: endChar,
-							},
-						},
+						Range:    nodeNameRange(doc, node, funcName),
 						Severity: protocol.DiagnosticSeverityError,
 						Source:   "ahoy",
 						Message:  message,
@@ -1077,7 +914,7 @@ func checkUndeclaredIdentifiers(doc *Document) []protocol.Diagnostic {
 			identifierName := node.Value
 			
 			// Look up the identifier in symbol table
-			sym := doc.SymbolTable.GlobalScope.Lookup(identifierName)
+			sym := lookupAtNode(doc, identifierName, node)
 			
 			if sym == nil {
 				// Identifier not found - determine what type it likely is
@@ -1100,26 +937,8 @@ func checkUndeclaredIdentifiers(doc *Document) []protocol.Diagnostic {
 				// Build error message
 				message := "Use of undeclared " + identifierType + " '" + identifierName + "'"
 				
-				lineText := ""
-				if node.Line > 0 && node.Line <= len(doc.Lines) {
-					lineText = doc.Lines[node.Line-1]
-				}
-				endChar := uint32(len(lineText))
-				if endChar == 0 {
-					endChar = uint32(len(identifierName) + 10)
-				}
-
 				diagnostic := protocol.Diagnostic{
-					Range: protocol.Range{
-						Start: protocol.Position{
-							Line:      uint32(node.Line - 1),
-							Character: 0,
-						},
-						End: protocol.Position{
-							Line:      uint32(node.Line - 1),
-							Character: endChar,
-						},
-					},
+					Range:    nodeRange(doc, node),
 					Severity: protocol.DiagnosticSeverityError,
 					Source:   "ahoy",
 					Message:  message,
@@ -1181,48 +1000,7 @@ func checkFunctionCallArgumentCounts(doc *Document) []protocol.Diagnostic {
 		return diagnostics
 	}
 
-	funcSignatures := make(map[string]*FunctionSignature)
-
-	var collectFunctions func(*ahoy.ASTNode)
-	collectFunctions = func(node *ahoy.ASTNode) {
-		if node == nil {
-			return
-		}
-
-		if node.Type == ahoy.NODE_FUNCTION {
-			funcName := node.Value
-			sig := &FunctionSignature{
-				Name:       funcName,
-				ReturnType: node.DataType,
-			}
-
-			if len(node.Children) > 0 && node.Children[0].Type == ahoy.NODE_BLOCK {
-				params := node.Children[0]
-				for _, param := range params.Children {
-					if param.Type == ahoy.NODE_IDENTIFIER {
-						paramInfo := ParameterInfo{
-							Name:       param.Value,
-							Type:       param.DataType,
-							HasDefault: param.DefaultValue != nil,
-						}
-						sig.Parameters = append(sig.Parameters, paramInfo)
-						if !paramInfo.HasDefault {
-							sig.RequiredParams++
-						}
-					}
-				}
-				sig.TotalParams = len(sig.Parameters)
-			}
-
-			funcSignatures[funcName] = sig
-		}
-
-		for _, child := range node.Children {
-			collectFunctions(child)
-		}
-	}
-
-	collectFunctions(doc.AST)
+	funcSignatures := collectFunctionSignatures(doc.AST)
 
 	var checkCalls func(*ahoy.ASTNode)
 	checkCalls = func(node *ahoy.ASTNode) {
@@ -1266,26 +1044,8 @@ func checkFunctionCallArgumentCounts(doc *Document) []protocol.Diagnostic {
 				}
 
 				if message != "" {
-					lineText := ""
-					if node.Line > 0 && node.Line <= len(doc.Lines) {
-						lineText = doc.Lines[node.Line-1]
-					}
-					endChar := uint32(len(lineText))
-					if endChar == 0 {
-						endChar = uint32(len(funcName) + 10)
-					}
-
 					diagnostic := protocol.Diagnostic{
-						Range: protocol.Range{
-							Start: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: 0,
-							},
-							End: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: endChar,
-							},
-						},
+						Range:    nodeRange(doc, node),
 						Severity: protocol.DiagnosticSeverityError,
 						Source:   "ahoy",
 						Message:  message,
@@ -1313,48 +1073,7 @@ func checkFunctionCallArgumentTypes(doc *Document) []protocol.Diagnostic {
 		return diagnostics
 	}
 
-	funcSignatures := make(map[string]*FunctionSignature)
-
-	var collectFunctions func(*ahoy.ASTNode)
-	collectFunctions = func(node *ahoy.ASTNode) {
-		if node == nil {
-			return
-		}
-
-		if node.Type == ahoy.NODE_FUNCTION {
-			funcName := node.Value
-			sig := &FunctionSignature{
-				Name:       funcName,
-				ReturnType: node.DataType,
-			}
-
-			if len(node.Children) > 0 && node.Children[0].Type == ahoy.NODE_BLOCK {
-				params := node.Children[0]
-				for _, param := range params.Children {
-					if param.Type == ahoy.NODE_IDENTIFIER {
-						paramInfo := ParameterInfo{
-							Name:       param.Value,
-							Type:       param.DataType,
-							HasDefault: param.DefaultValue != nil,
-						}
-						sig.Parameters = append(sig.Parameters, paramInfo)
-						if !paramInfo.HasDefault {
-							sig.RequiredParams++
-						}
-					}
-				}
-				sig.TotalParams = len(sig.Parameters)
-			}
-
-			funcSignatures[funcName] = sig
-		}
-
-		for _, child := range node.Children {
-			collectFunctions(child)
-		}
-	}
-
-	collectFunctions(doc.AST)
+	funcSignatures := collectFunctionSignatures(doc.AST)
 
 	var checkCalls func(*ahoy.ASTNode)
 	checkCalls = func(node *ahoy.ASTNode) {
@@ -1435,26 +1154,8 @@ func checkFunctionCallArgumentTypes(doc *Document) []protocol.Diagnostic {
 
 						message := "expected function arguments " + expectedStr + " got " + actualStr
 
-						lineText := ""
-						if node.Line > 0 && node.Line <= len(doc.Lines) {
-							lineText = doc.Lines[node.Line-1]
-						}
-						endChar := uint32(len(lineText))
-						if endChar == 0 {
-							endChar = uint32(len(funcName) + 10)
-						}
-
 						diagnostic := protocol.Diagnostic{
-							Range: protocol.Range{
-								Start: protocol.Position{
-									Line:      uint32(node.Line - 1),
-									Character: 0,
-								},
-								End: protocol.Position{
-									Line:      uint32(node.Line - 1),
-									Character: endChar,
-								},
-							},
+							Range:    nodeRange(doc, node),
 							Severity: protocol.DiagnosticSeverityError,
 							Source:   "ahoy",
 							Message:  message,
@@ -1481,12 +1182,90 @@ type FunctionSignature struct {
 	RequiredParams int
 	TotalParams    int
 	ReturnType     string
+	Line           int
 }
 
 type ParameterInfo struct {
 	Name       string
 	Type       string
 	HasDefault bool
+	Default    string // Source text of the default value, if any
+}
+
+// collectFunctionSignatures gathers the signature of every user-defined function
+func collectFunctionSignatures(ast *ahoy.ASTNode) map[string]*FunctionSignature {
+	funcSignatures := make(map[string]*FunctionSignature)
+
+	var collectFunctions func(*ahoy.ASTNode)
+	collectFunctions = func(node *ahoy.ASTNode) {
+		if node == nil {
+			return
+		}
+
+		if node.Type == ahoy.NODE_FUNCTION {
+			funcName := node.Value
+			sig := &FunctionSignature{
+				Name:       funcName,
+				ReturnType: node.DataType,
+				Line:       node.Line,
+			}
+
+			if len(node.Children) > 0 && node.Children[0].Type == ahoy.NODE_BLOCK {
+				params := node.Children[0]
+				for _, param := range params.Children {
+					if param.Type == ahoy.NODE_IDENTIFIER {
+						paramInfo := ParameterInfo{
+							Name:       param.Value,
+							Type:       param.DataType,
+							HasDefault: param.DefaultValue != nil,
+						}
+						if paramInfo.HasDefault {
+							paramInfo.Default = expressionText(param.DefaultValue)
+						}
+						sig.Parameters = append(sig.Parameters, paramInfo)
+						if !paramInfo.HasDefault {
+							sig.RequiredParams++
+						}
+					}
+				}
+				sig.TotalParams = len(sig.Parameters)
+			}
+
+			funcSignatures[funcName] = sig
+		}
+
+		for _, child := range node.Children {
+			collectFunctions(child)
+		}
+	}
+
+	collectFunctions(ast)
+	return funcSignatures
+}
+
+// expressionText renders a simple literal or identifier back to source form
+func expressionText(node *ahoy.ASTNode) string {
+	if node == nil {
+		return ""
+	}
+
+	switch node.Type {
+	case ahoy.NODE_STRING:
+		return "\"" + node.Value + "\""
+	case ahoy.NODE_F_STRING:
+		return "f\"" + node.Value + "\""
+	case ahoy.NODE_CHAR:
+		return "'" + node.Value + "'"
+	case ahoy.NODE_ARRAY_LITERAL:
+		return "[...]"
+	case ahoy.NODE_DICT_LITERAL:
+		return "{...}"
+	default:
+		if node.Value != "" {
+			return node.Value
+		}
+		return "..."
+	}
 }
 
 func intToString(n int) string {
@@ -1535,26 +1314,8 @@ func checkTypeMismatches(doc *Document) []protocol.Diagnostic {
 				actualType := inferExpressionType(node.Children[0])
 
 				if actualType != "unknown" && actualType != expectedType && expectedType != "generic" {
-					lineText := ""
-					if node.Line > 0 && node.Line <= len(doc.Lines) {
-						lineText = doc.Lines[node.Line-1]
-					}
-					endChar := uint32(len(lineText))
-					if endChar == 0 {
-						endChar = 30
-					}
-
 					diagnostic := protocol.Diagnostic{
-						Range: protocol.Range{
-							Start: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: 0,
-							},
-							End: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: endChar,
-							},
-						},
+						Range:    nodeRange(doc, node.Children[0]),
 						Severity: protocol.DiagnosticSeverityError,
 						Source:   "ahoy",
 						Message:  "expected " + expectedType + " got " + actualType,
@@ -1574,26 +1335,8 @@ func checkTypeMismatches(doc *Document) []protocol.Diagnostic {
 				actualType := inferExpressionType(node.Children[0])
 
 				if actualType != "unknown" && actualType != expectedType && expectedType != "generic" {
-					lineText := ""
-					if node.Line > 0 && node.Line <= len(doc.Lines) {
-						lineText = doc.Lines[node.Line-1]
-					}
-					endChar := uint32(len(lineText))
-					if endChar == 0 {
-						endChar = 30
-					}
-
 					diagnostic := protocol.Diagnostic{
-						Range: protocol.Range{
-							Start: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: 0,
-							},
-							End: protocol.Position{
-								Line:      uint32(node.Line - 1),
-								Character: endChar,
-							},
-						},
+						Range:    nodeRange(doc, node.Children[0]),
 						Severity: protocol.DiagnosticSeverityError,
 						Source:   "ahoy",
 						Message:  "expected " + expectedType + " got " + actualType,
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+func (s *Server) handleDocumentHighlight(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.DocumentHighlightParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil || doc.SymbolTable == nil {
+		return reply(ctx, []protocol.DocumentHighlight{}, nil)
+	}
+
+	// References are resolved through the scope tree when the table is
+	// built, so a shadowed name in another function is a different symbol
+	symbol, _ := doc.SymbolTable.ReferenceAt(int(params.Position.Line)+1, sourceColumn(doc.Lines, params.Position))
+	if symbol == nil {
+		return reply(ctx, []protocol.DocumentHighlight{}, nil)
+	}
+
+	refs := doc.SymbolTable.FindReferences(symbol, true)
+
+	highlights := make([]protocol.DocumentHighlight, 0, len(refs))
+	for _, ref := range refs {
+		highlights = append(highlights, protocol.DocumentHighlight{
+			Range: referenceToRange(doc.Lines, ref),
+			Kind:  highlightKind(ref),
+		})
+	}
+
+	return reply(ctx, highlights, nil)
+}
+
+// highlightKind marks assignments as writes and other uses as reads.
+// Declarations that don't assign a value, like function names and
+// parameters, are plain text highlights.
+func highlightKind(ref Reference) protocol.DocumentHighlightKind {
+	switch {
+	case ref.IsWrite:
+		return protocol.DocumentHighlightKindWrite
+	case ref.IsDeclaration:
+		return protocol.DocumentHighlightKindText
+	default:
+		return protocol.DocumentHighlightKindRead
+	}
+}
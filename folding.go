@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"ahoy"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+func (s *Server) handleFoldingRange(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.FoldingRangeParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil || doc.Lines == nil {
+		return reply(ctx, []protocol.FoldingRange{}, nil)
+	}
+
+	return reply(ctx, foldingRanges(doc), nil)
+}
+
+// foldingRanges collects the foldable regions of doc. Blocks come from the
+// AST when the document parsed cleanly and from indentation otherwise.
+func foldingRanges(doc *Document) []protocol.FoldingRange {
+	// Clients show one fold per start line, so keep the longest
+	blocks := map[int]int{}
+	if doc.AST != nil && len(doc.Errors) == 0 {
+		for _, child := range doc.AST.Children {
+			collectBlockFolds(child, blocks, 0)
+		}
+	} else {
+		collectIndentationFolds(doc.Lines, blocks)
+	}
+
+	ranges := []protocol.FoldingRange{}
+	for start, end := range blocks {
+		ranges = append(ranges, protocol.FoldingRange{
+			StartLine: uint32(start),
+			EndLine:   uint32(end),
+		})
+	}
+	ranges = append(ranges, lineRunFolds(doc.Lines, isCommentLine, protocol.CommentFoldingRange)...)
+	ranges = append(ranges, lineRunFolds(doc.Lines, isImportLine, protocol.ImportsFoldingRange)...)
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].StartLine < ranges[j].StartLine
+	})
+
+	return ranges
+}
+
+// collectBlockFolds adds a fold for every node whose subtree spans several
+// lines: functions, if/loop/switch statements, struct, enum and nested type
+// declarations, and multi-line literals. Lines are stored 0-based.
+func collectBlockFolds(node *ahoy.ASTNode, blocks map[int]int, depth int) {
+	if node == nil || depth > 1000 {
+		return
+	}
+
+	if node.Line > 0 {
+		end := subtreeEndLine(node, 0)
+		if end > node.Line && end-1 > blocks[node.Line-1] {
+			blocks[node.Line-1] = end - 1
+		}
+	}
+
+	for _, child := range node.Children {
+		collectBlockFolds(child, blocks, depth+1)
+	}
+}
+
+// collectIndentationFolds folds each line followed by more deeply indented
+// lines, for documents the parser couldn't handle
+func collectIndentationFolds(lines []string, blocks map[int]int) {
+	f := &formatter{}
+
+	// Open blocks as (start line, indentation width) pairs
+	type openBlock struct{ line, width int }
+	stack := []openBlock{}
+	lastCode := -1
+
+	closeBlocks := func(width int) {
+		for len(stack) > 0 && stack[len(stack)-1].width >= width {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if lastCode > top.line {
+				blocks[top.line] = lastCode
+			}
+		}
+	}
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "?") {
+			continue
+		}
+
+		width := f.indentWidth(line)
+		closeBlocks(width)
+		stack = append(stack, openBlock{i, width})
+		lastCode = i
+	}
+	closeBlocks(0)
+}
+
+// lineRunFolds folds runs of two or more consecutive lines matching match
+func lineRunFolds(lines []string, match func(string) bool, kind protocol.FoldingRangeKind) []protocol.FoldingRange {
+	ranges := []protocol.FoldingRange{}
+
+	for start := 0; start < len(lines); start++ {
+		if !match(lines[start]) {
+			continue
+		}
+
+		end := start
+		for end+1 < len(lines) && match(lines[end+1]) {
+			end++
+		}
+		if end > start {
+			ranges = append(ranges, protocol.FoldingRange{
+				StartLine: uint32(start),
+				EndLine:   uint32(end),
+				Kind:      kind,
+			})
+		}
+		start = end
+	}
+
+	return ranges
+}
+
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "?")
+}
+
+func isImportLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "import" || strings.HasPrefix(trimmed, "import ")
+}
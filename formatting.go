@@ -0,0 +1,670 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"ahoy"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// defaultMaxBlankLines is the longest run of blank lines the formatter keeps
+// unless configured otherwise
+const defaultMaxBlankLines = 2
+
+func (s *Server) handleFormatting(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.DocumentFormattingParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.liveDocument(ctx, params.TextDocument.URI)
+	if !canFormat(doc) {
+		return reply(ctx, []protocol.TextEdit{}, nil)
+	}
+
+	edits := s.formatDocument(ctx, doc, params.Options, 0, len(doc.Lines)-1)
+	return reply(ctx, edits, nil)
+}
+
+func (s *Server) handleRangeFormatting(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.DocumentRangeFormattingParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.liveDocument(ctx, params.TextDocument.URI)
+	if !canFormat(doc) {
+		return reply(ctx, []protocol.TextEdit{}, nil)
+	}
+
+	// A range ending at the start of a line doesn't include that line
+	first := int(params.Range.Start.Line)
+	last := int(params.Range.End.Line)
+	if last > first && params.Range.End.Character == 0 {
+		last--
+	}
+
+	edits := s.formatDocument(ctx, doc, params.Options, first, last)
+	return reply(ctx, edits, nil)
+}
+
+func (s *Server) handleOnTypeFormatting(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.DocumentOnTypeFormattingParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	// A document without tokens was never tokenized, because it is over
+	// the size limit or the parser timed out, and is left alone
+	doc := s.liveDocument(ctx, params.TextDocument.URI)
+	if doc == nil || doc.Tokens == nil || params.Ch != "\n" || params.Position.Line == 0 {
+		return reply(ctx, []protocol.TextEdit{}, nil)
+	}
+
+	// The document is usually incomplete while typing, so only the line
+	// that was just finished is formatted and no clean parse is required
+	line := int(params.Position.Line) - 1
+	edits := s.formatDocument(ctx, doc, params.Options, line, line)
+	return reply(ctx, edits, nil)
+}
+
+// canFormat reports whether doc parsed cleanly. In an indentation-sensitive
+// language, reformatting code the parser doesn't understand could change
+// its meaning, so broken documents are left alone.
+func canFormat(doc *Document) bool {
+	if doc == nil || doc.AST == nil {
+		return false
+	}
+	if len(doc.Errors) > 0 {
+		debugLog.Printf("Not formatting %s: %d parse errors", doc.URI, len(doc.Errors))
+		return false
+	}
+	return true
+}
+
+// formatDocument returns the edits that format lines first..last (0-based,
+// inclusive) of doc. No edits are returned if formatting would change the
+// document's tokens or, for a document that parsed cleanly, the shape of
+// its AST. The formatting settings override the editor's options.
+func (s *Server) formatDocument(ctx context.Context, doc *Document, options protocol.FormattingOptions, first, last int) []protocol.TextEdit {
+	preferences := s.getSettings().Formatting
+	functions := collectFunctionSignatures(doc.AST)
+	f := &formatter{
+		options:       preferences.apply(options),
+		maxBlankLines: preferences.MaxBlankLines,
+		isFunction: func(name string) bool {
+			_, ok := functions[name]
+			return ok || isBuiltinFunction(name)
+		},
+	}
+
+	// Indentation comes from the AST when it describes the whole document
+	var levels map[int]int
+	clean := doc.AST != nil && len(doc.Errors) == 0
+	if clean {
+		levels = indentLevels(doc.AST)
+	}
+
+	formatted := f.formatLines(doc.Lines, levels)
+
+	if first < 0 {
+		first = 0
+	}
+	if last >= len(doc.Lines) {
+		last = len(doc.Lines) - 1
+	}
+	if first > last {
+		return []protocol.TextEdit{}
+	}
+
+	// Only lines inside the range take their formatted text
+	result := make([]formattedLine, len(doc.Lines))
+	for i, line := range doc.Lines {
+		if i >= first && i <= last {
+			result[i] = formatted[i]
+		} else {
+			result[i] = formattedLine{text: line}
+		}
+	}
+
+	text := joinFormattedLines(result)
+	if !sameTokens(doc.Content, text) {
+		debugLog.Printf("Formatting %s would change its tokens, skipping", doc.URI)
+		return []protocol.TextEdit{}
+	}
+
+	// Indentation is significant, so the result must parse to the same tree
+	if clean {
+		reparsed := parseDocument(ctx, doc.URI, text, doc.Version, s.parseTimeout())
+		if reparsed == nil || len(reparsed.Errors) > 0 || !sameStructure(doc.AST, reparsed.AST, 0) {
+			debugLog.Printf("Formatting %s would change its structure, skipping", doc.URI)
+			return []protocol.TextEdit{}
+		}
+	}
+
+	return lineEdits(doc.Lines, result)
+}
+
+// formattedLine is the formatter's output for one source line
+type formattedLine struct {
+	text    string
+	deleted bool // The line is removed along with its line break
+}
+
+// formatter pretty-prints Ahoy source line by line
+type formatter struct {
+	options       protocol.FormattingOptions
+	maxBlankLines int
+	isFunction    func(string) bool
+}
+
+// formatLines formats every line of a document. levels holds the
+// indentation level of the lines that start an AST node, by 0-based line
+// index; other lines (else, end, comments) are placed relative to them by
+// their indentation in the source. The result has one entry per input line
+// so that edits can be mapped back to the source.
+func (f *formatter) formatLines(lines []string, levels map[int]int) []formattedLine {
+	result := make([]formattedLine, len(lines))
+
+	// The text after the final line break is not a line of its own
+	count := len(lines)
+	if count > 1 && lines[count-1] == "" {
+		count--
+	}
+
+	// The enclosing blocks, outermost first
+	blocks := []indentBlock{{}}
+	blank := 0
+	lastCode := -1
+
+	for i := 0; i < count; i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		cr := ""
+		if len(line) != len(lines[i]) {
+			cr = "\r"
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			blank++
+			// Drop leading blank lines and overly long runs
+			result[i] = formattedLine{text: cr, deleted: lastCode < 0 || blank > f.maxBlankLines}
+			continue
+		}
+		blank = 0
+		lastCode = i
+
+		code := trimmed
+		comment := ""
+		if col := commentColumn(trimmed); col >= 0 {
+			code = strings.TrimSpace(trimmed[:col])
+			comment = trimmed[col:]
+		}
+
+		width := f.indentWidth(line)
+		level := 0
+		if code == "" {
+			level = commentLevel(blocks, width)
+		} else if astLevel, ok := levels[i]; ok {
+			blocks = placeBlock(blocks, width, astLevel)
+			level = astLevel
+		} else {
+			blocks = enterBlock(blocks, width)
+			level = blocks[len(blocks)-1].level
+		}
+
+		text := f.indentUnit(level)
+		if code != "" {
+			text += f.formatCode(code)
+			if comment != "" {
+				text += " "
+			}
+		}
+		text += comment
+
+		result[i] = formattedLine{text: text + cr}
+	}
+
+	// Trailing blank lines are removed; the final line break is kept
+	for i := lastCode + 1; i < count; i++ {
+		result[i] = formattedLine{deleted: true}
+	}
+	if count < len(lines) {
+		result[count] = formattedLine{text: ""}
+	} else if f.options.InsertFinalNewline && lastCode >= 0 {
+		result[lastCode].text += "\n"
+	}
+
+	return result
+}
+
+// indentBlock is an enclosing block: the indentation width of its lines in
+// the source and the level they are formatted at
+type indentBlock struct {
+	width int
+	level int
+}
+
+// enterBlock updates the block stack for a code line indented by width
+// whose level isn't known from the AST
+func enterBlock(blocks []indentBlock, width int) []indentBlock {
+	top := blocks[len(blocks)-1]
+	if width > top.width {
+		return append(blocks, indentBlock{width, top.level + 1})
+	}
+
+	for len(blocks) > 1 && blocks[len(blocks)-1].width > width {
+		blocks = blocks[:len(blocks)-1]
+	}
+	// A dedent to a width no enclosing block uses starts a new level
+	if top := blocks[len(blocks)-1]; top.width < width {
+		blocks = append(blocks, indentBlock{width, top.level + 1})
+	}
+	return blocks
+}
+
+// placeBlock updates the block stack for a code line indented by width
+// whose level is known from the AST
+func placeBlock(blocks []indentBlock, width, level int) []indentBlock {
+	for len(blocks) > 1 && blocks[len(blocks)-1].width >= width {
+		blocks = blocks[:len(blocks)-1]
+	}
+	if blocks[len(blocks)-1].width == width {
+		blocks[len(blocks)-1].level = level
+		return blocks
+	}
+	return append(blocks, indentBlock{width, level})
+}
+
+// commentLevel returns the indentation level of a comment-only line without
+// changing the block stack. A comment indented past the current block is
+// treated as the first line of a nested block.
+func commentLevel(blocks []indentBlock, width int) int {
+	top := blocks[len(blocks)-1]
+	if width > top.width {
+		return top.level + 1
+	}
+
+	level := 0
+	for _, block := range blocks {
+		if block.width <= width {
+			level = block.level
+		}
+	}
+	return level
+}
+
+// indentLevels returns the indentation level of every line that starts an
+// AST node, by 0-based line index. A node is one level deeper than each
+// enclosing node that begins on an earlier line, so block bodies, struct
+// fields, nested type fields and enum values all nest under the line that
+// introduces them.
+func indentLevels(ast *ahoy.ASTNode) map[int]int {
+	levels := map[int]int{}
+
+	var walk func(node *ahoy.ASTNode, level, line, depth int)
+	walk = func(node *ahoy.ASTNode, level, line, depth int) {
+		if node == nil || depth > 1000 {
+			return
+		}
+
+		// Programs and blocks only group their statements
+		if node.Type != ahoy.NODE_PROGRAM && node.Type != ahoy.NODE_BLOCK && node.Line > 0 && node.Line >= line {
+			if line > 0 && node.Line > line {
+				level++
+			}
+			line = node.Line
+
+			if current, ok := levels[line-1]; !ok || level < current {
+				levels[line-1] = level
+			}
+		}
+
+		for _, child := range node.Children {
+			walk(child, level, line, depth+1)
+		}
+	}
+	walk(ast, 0, 0, 0)
+
+	return levels
+}
+
+// sameStructure reports whether two ASTs have the same nodes, ignoring
+// their positions
+func sameStructure(a, b *ahoy.ASTNode, depth int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if depth > 1000 {
+		return true
+	}
+	if a.Type != b.Type || a.Value != b.Value || a.DataType != b.DataType || len(a.Children) != len(b.Children) {
+		return false
+	}
+	if !sameStructure(a.DefaultValue, b.DefaultValue, depth+1) {
+		return false
+	}
+
+	for i := range a.Children {
+		if !sameStructure(a.Children[i], b.Children[i], depth+1) {
+			return false
+		}
+	}
+	return true
+}
+
+// indentWidth measures a line's leading whitespace in columns
+func (f *formatter) indentWidth(line string) int {
+	tabSize := f.tabSize()
+	width := 0
+	for _, ch := range line {
+		switch ch {
+		case ' ':
+			width++
+		case '\t':
+			width += tabSize - width%tabSize
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+func (f *formatter) indentUnit(level int) string {
+	if f.options.InsertSpaces {
+		return strings.Repeat(" ", level*f.tabSize())
+	}
+	return strings.Repeat("\t", level)
+}
+
+func (f *formatter) tabSize() int {
+	if f.options.TabSize == 0 {
+		return 4
+	}
+	return int(f.options.TabSize)
+}
+
+// lexemeKind classifies the pieces of a line of code
+type lexemeKind int
+
+const (
+	lexemeWord lexemeKind = iota
+	lexemeString
+	lexemePunct
+)
+
+// pipeRole tells whether a | opens or closes an argument list
+type pipeRole int
+
+const (
+	pipeNone pipeRole = iota
+	pipeOpen
+	pipeClose
+)
+
+type lexeme struct {
+	text   string
+	kind   lexemeKind
+	offset int  // Byte offset in the lexed code
+	space  bool // Whitespace preceded the lexeme in the source
+	pipe   pipeRole
+}
+
+// operatorPairs are the two-character operators kept as one lexeme
+var operatorPairs = map[string]bool{
+	"::": true, "==": true, "!=": true, "<=": true, ">=": true,
+	"->": true, "+=": true, "-=": true, "*=": true, "/=": true,
+}
+
+// formatCode normalizes the spacing of a line of code (without its
+// indentation or comment)
+func (f *formatter) formatCode(code string) string {
+	lexemes := lexCode(code)
+	f.classifyPipes(lexemes)
+
+	var b strings.Builder
+	for i, lx := range lexemes {
+		if i > 0 && spaceBefore(lexemes, i) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(lx.text)
+	}
+	return b.String()
+}
+
+// lexCode splits a line of code into words, strings and punctuation
+func lexCode(code string) []lexeme {
+	lexemes := []lexeme{}
+	space := false
+
+	for i := 0; i < len(code); {
+		ch := code[i]
+		if ch == ' ' || ch == '\t' || ch == '\r' {
+			space = true
+			i++
+			continue
+		}
+
+		start := i
+		kind := lexemePunct
+		switch {
+		case isQuote(ch):
+			i = skipString(code, i)
+			kind = lexemeString
+
+		case isWordChar(rune(ch)) || ch >= 0x80:
+			for i < len(code) && (isWordChar(rune(code[i])) || code[i] >= 0x80) {
+				i++
+			}
+			// Decimal fraction
+			if isDigit(ch) && i+1 < len(code) && code[i] == '.' && isDigit(code[i+1]) {
+				i++
+				for i < len(code) && isWordChar(rune(code[i])) {
+					i++
+				}
+			}
+			kind = lexemeWord
+			// f-string prefix
+			if code[start:i] == "f" && i < len(code) && isQuote(code[i]) {
+				i = skipString(code, i)
+				kind = lexemeString
+			}
+
+		default:
+			i++
+			if i < len(code) && operatorPairs[code[start:i+1]] {
+				i++
+			}
+		}
+
+		lexemes = append(lexemes, lexeme{text: code[start:i], kind: kind, offset: start, space: space})
+		space = false
+	}
+
+	return lexemes
+}
+
+// skipString returns the offset just past the string literal starting at i
+func skipString(code string, i int) int {
+	quote := code[i]
+	for i++; i < len(code); i++ {
+		if code[i] == '\\' {
+			i++
+		} else if code[i] == quote {
+			return i + 1
+		}
+	}
+	return len(code)
+}
+
+// classifyPipes marks each | as opening or closing an argument list, using
+// the same rules as signature help
+func (f *formatter) classifyPipes(lexemes []lexeme) {
+	open := 0
+	for i := range lexemes {
+		if lexemes[i].text != "|" {
+			continue
+		}
+
+		opens := open == 0 || i == 0
+		if i > 0 {
+			prev := lexemes[i-1]
+			isMethod := i > 1 && lexemes[i-2].text == "."
+			if prev.text == "::" || (prev.kind == lexemeWord && (isMethod || f.isFunction(prev.text))) {
+				opens = true
+			}
+		}
+
+		if opens {
+			lexemes[i].pipe = pipeOpen
+			open++
+		} else {
+			lexemes[i].pipe = pipeClose
+			open--
+		}
+	}
+}
+
+// spaceBefore decides whether a space separates lexemes[i] from the
+// previous lexeme. Anything without a rule keeps the source's choice.
+func spaceBefore(lexemes []lexeme, i int) bool {
+	prev, cur := lexemes[i-1], lexemes[i]
+
+	switch {
+	case cur.text == ",":
+		return false
+	case prev.text == ",":
+		return true
+	case prev.text == "." || cur.text == ".":
+		return false
+	case cur.text == "::":
+		// greet :: |name: string|: but age:: 29
+		return i+1 < len(lexemes) && lexemes[i+1].pipe == pipeOpen
+	case cur.text == ":":
+		return false
+	case prev.text == ":" || prev.text == "::":
+		return true
+	case cur.text == "=" || prev.text == "=":
+		return true
+	case cur.pipe == pipeOpen:
+		// Calls attach the argument list to the callee
+		if prev.kind == lexemeWord && !isKeyword(prev.text) {
+			return false
+		}
+		return cur.space
+	case prev.pipe == pipeOpen || cur.pipe == pipeClose:
+		return false
+	case isOpenBracket(prev.text) || isCloseBracket(cur.text):
+		return false
+	default:
+		return cur.space
+	}
+}
+
+// isKeyword reports whether word is a language keyword rather than a callee
+func isKeyword(word string) bool {
+	return getKeywordHover(word) != "" && !isBuiltinFunction(word)
+}
+
+func isOpenBracket(text string) bool {
+	return text == "(" || text == "[" || text == "{"
+}
+
+func isCloseBracket(text string) bool {
+	return text == ")" || text == "]" || text == "}"
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// joinFormattedLines rebuilds document text from formatter output
+func joinFormattedLines(lines []formattedLine) string {
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !line.deleted {
+			kept = append(kept, line.text)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// sameTokens reports whether two versions of a document tokenize to the
+// same sequence. Whitespace-only tokens (indentation, line breaks) are
+// ignored since changing them is the formatter's job.
+func sameTokens(before, after string) bool {
+	a := significantTokens(ahoy.Tokenize(before))
+	b := significantTokens(ahoy.Tokenize(after))
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+func significantTokens(tokens []ahoy.Token) []ahoy.Token {
+	result := make([]ahoy.Token, 0, len(tokens))
+	for _, tok := range tokens {
+		if strings.TrimSpace(tok.Value) != "" {
+			result = append(result, tok)
+		}
+	}
+	return result
+}
+
+// lineEdits turns per-line formatter output into text edits. Each run of
+// consecutive changed lines becomes a single edit so edits never touch.
+func lineEdits(original []string, formatted []formattedLine) []protocol.TextEdit {
+	edits := []protocol.TextEdit{}
+
+	changed := func(i int) bool {
+		return formatted[i].deleted || formatted[i].text != original[i]
+	}
+
+	for start := 0; start < len(original); start++ {
+		if !changed(start) {
+			continue
+		}
+
+		end := start
+		for end+1 < len(original) && changed(end+1) {
+			end++
+		}
+
+		kept := []string{}
+		for i := start; i <= end; i++ {
+			if !formatted[i].deleted {
+				kept = append(kept, formatted[i].text)
+			}
+		}
+
+		rng := protocol.Range{
+			Start: protocol.Position{Line: uint32(start)},
+			End:   protocol.Position{Line: uint32(end), Character: uint32(utf16Length(original[end]))},
+		}
+		newText := strings.Join(kept, "\n")
+
+		// Removing every line of the run also removes one line break
+		if len(kept) == 0 {
+			if end+1 < len(original) {
+				rng.End = protocol.Position{Line: uint32(end + 1)}
+			} else if start > 0 {
+				rng.Start = protocol.Position{Line: uint32(start - 1), Character: uint32(utf16Length(original[start-1]))}
+			}
+		}
+
+		edits = append(edits, protocol.TextEdit{Range: rng, NewText: newText})
+		start = end
+	}
+
+	return edits
+}
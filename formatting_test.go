@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"ahoy"
+
+	"go.lsp.dev/protocol"
+)
+
+func testFormatter(insertSpaces bool) *formatter {
+	return &formatter{
+		options:       protocol.FormattingOptions{TabSize: 4, InsertSpaces: insertSpaces},
+		maxBlankLines: 1,
+		isFunction: func(name string) bool {
+			return name == "greet"
+		},
+	}
+}
+
+func TestFormatCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"x:1", "x: 1"},
+		{"a  +  b", "a + b"},
+		{"greet|a,b|", "greet|a, b|"},
+		{"greet | a , b |", "greet|a, b|"},
+		{`greet|"a  b"|`, `greet|"a  b"|`},
+		{"obj . method|x|", "obj.method|x|"},
+		{"arr[ 0 ]", "arr[0]"},
+		{"total: greet|greet|1||", "total: greet|greet|1||"},
+		{"x:1.5", "x: 1.5"},
+	}
+
+	f := testFormatter(true)
+	for _, tt := range tests {
+		if got := f.formatCode(tt.code); got != tt.want {
+			t.Errorf("formatCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		levels map[int]int
+		spaces bool
+		want   string
+	}{
+		{
+			name:   "indentation from the source",
+			lines:  []string{"func f do", "  x:1", "end"},
+			spaces: true,
+			want:   "func f do\n    x: 1\nend",
+		},
+		{
+			name:   "indentation from the AST",
+			lines:  []string{"if a then", "        x:1", "  y:2", "end"},
+			levels: map[int]int{0: 0, 1: 1, 2: 1},
+			spaces: true,
+			want:   "if a then\n    x: 1\n    y: 2\nend",
+		},
+		{
+			name:   "tabs",
+			lines:  []string{"func f do", "  x:1", "end"},
+			spaces: false,
+			want:   "func f do\n\tx: 1\nend",
+		},
+		{
+			name:   "blank line runs",
+			lines:  []string{"", "x:1", "", "", "y:2", "", ""},
+			spaces: true,
+			want:   "x: 1\n\ny: 2\n",
+		},
+		{
+			name:   "comments",
+			lines:  []string{"func f do", "  ? note", "  x:1   ? why", "end"},
+			spaces: true,
+			want:   "func f do\n    ? note\n    x: 1 ? why\nend",
+		},
+		{
+			name:   "CRLF line endings",
+			lines:  []string{"x:1\r", "y:2\r", ""},
+			spaces: true,
+			want:   "x: 1\r\ny: 2\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted := testFormatter(tt.spaces).formatLines(tt.lines, tt.levels)
+		if len(formatted) != len(tt.lines) {
+			t.Errorf("%s: %d formatted lines for %d input lines", tt.name, len(formatted), len(tt.lines))
+			continue
+		}
+		if got := joinFormattedLines(formatted); got != tt.want {
+			t.Errorf("%s: formatted to %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIndentLevels(t *testing.T) {
+	// 1 func f do
+	// 2     x: 1
+	// 3     if x then
+	// 4         y: 2
+	// 5     end
+	// 6 end
+	// 7 z: 3
+	ast := &ahoy.ASTNode{Type: ahoy.NODE_PROGRAM, Children: []*ahoy.ASTNode{
+		{Type: ahoy.NODE_FUNCTION, Value: "f", Line: 1, Children: []*ahoy.ASTNode{
+			{Type: ahoy.NODE_BLOCK, Line: 1},
+			{Type: ahoy.NODE_BLOCK, Line: 1, Children: []*ahoy.ASTNode{
+				{Type: ahoy.NODE_ASSIGNMENT, Value: "x", Line: 2, Children: []*ahoy.ASTNode{
+					{Type: ahoy.NODE_NUMBER, Value: "1", Line: 2},
+				}},
+				{Type: ahoy.NODE_IF_STATEMENT, Line: 3, Children: []*ahoy.ASTNode{
+					{Type: ahoy.NODE_IDENTIFIER, Value: "x", Line: 3},
+					{Type: ahoy.NODE_BLOCK, Line: 3, Children: []*ahoy.ASTNode{
+						{Type: ahoy.NODE_ASSIGNMENT, Value: "y", Line: 4},
+					}},
+				}},
+			}},
+		}},
+		{Type: ahoy.NODE_ASSIGNMENT, Value: "z", Line: 7},
+	}}
+
+	want := map[int]int{0: 0, 1: 1, 2: 1, 3: 2, 6: 0}
+	got := indentLevels(ast)
+	if len(got) != len(want) {
+		t.Fatalf("indentLevels = %v, want %v", got, want)
+	}
+	for line, level := range want {
+		if got[line] != level {
+			t.Errorf("line %d: level %d, want %d", line, got[line], level)
+		}
+	}
+}
+
+func TestSameStructure(t *testing.T) {
+	leaf := func(value string, line int) *ahoy.ASTNode {
+		return &ahoy.ASTNode{Type: ahoy.NODE_IDENTIFIER, Value: value, Line: line}
+	}
+	tree := func(line int, children ...*ahoy.ASTNode) *ahoy.ASTNode {
+		return &ahoy.ASTNode{Type: ahoy.NODE_BLOCK, Line: line, Children: children}
+	}
+
+	tests := []struct {
+		name string
+		a, b *ahoy.ASTNode
+		want bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", tree(1), nil, false},
+		{"moved lines", tree(1, leaf("a", 1)), tree(3, leaf("a", 4)), true},
+		{"different value", tree(1, leaf("a", 1)), tree(1, leaf("b", 1)), false},
+		{"extra child", tree(1, leaf("a", 1)), tree(1, leaf("a", 1), leaf("b", 2)), false},
+		{"child moved into a block", tree(1, leaf("a", 1), tree(2)), tree(1, tree(2, leaf("a", 3))), false},
+	}
+
+	for _, tt := range tests {
+		if got := sameStructure(tt.a, tt.b, 0); got != tt.want {
+			t.Errorf("%s: sameStructure = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDocument(t *testing.T) {
+	content := "x:1\ny:2\nz:3\n"
+	doc := &Document{
+		URI:         "file:///test.ahoy",
+		Content:     content,
+		Lines:       strings.Split(content, "\n"),
+		SymbolTable: NewSymbolTable(),
+	}
+	options := protocol.FormattingOptions{TabSize: 4, InsertSpaces: true}
+
+	tests := []struct {
+		name        string
+		first, last int
+		want        []protocol.TextEdit
+	}{
+		{
+			name:  "whole document",
+			first: 0, last: len(doc.Lines) - 1,
+			want: []protocol.TextEdit{{
+				Range:   protocol.Range{End: protocol.Position{Line: 2, Character: 3}},
+				NewText: "x: 1\ny: 2\nz: 3",
+			}},
+		},
+		{
+			name:  "one line",
+			first: 1, last: 1,
+			want: []protocol.TextEdit{{
+				Range: protocol.Range{
+					Start: protocol.Position{Line: 1},
+					End:   protocol.Position{Line: 1, Character: 3},
+				},
+				NewText: "y: 2",
+			}},
+		},
+		{
+			name:  "empty range",
+			first: 2, last: 1,
+			want: []protocol.TextEdit{},
+		},
+	}
+
+	s := NewServer(nil)
+	for _, tt := range tests {
+		got := s.formatDocument(context.Background(), doc, options, tt.first, tt.last)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: edits %+v, want %+v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: edit %d = %+v, want %+v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
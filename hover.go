@@ -9,6 +9,9 @@ import (
 	"go.lsp.dev/protocol"
 )
 
+// defaultMaxHoverDocumentSize is the largest document that gets hovers
+const defaultMaxHoverDocumentSize = 1000000
+
 func (s *Server) handleHover(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
 	var params protocol.HoverParams
 	if err := json.Unmarshal(req.Params(), &params); err != nil {
@@ -23,7 +26,7 @@ func (s *Server) handleHover(ctx context.Context, reply jsonrpc2.Replier, req js
 	}
 
 	// Safety check: prevent processing huge files
-	if len(doc.Content) > 1000000 {
+	if len(doc.Content) > s.getSettings().Limits.MaxHoverDocumentSize {
 		return reply(ctx, nil, nil)
 	}
 
@@ -33,15 +36,15 @@ func (s *Server) handleHover(ctx context.Context, reply jsonrpc2.Replier, req js
 	}
 
 	// Get the word at the cursor position
-	word := getWordAtPosition(doc, int(params.Position.Line), int(params.Position.Character))
+	word := getWordAtPosition(doc, int(params.Position.Line), sourceColumn(doc.Lines, params.Position))
 	if word == "" {
 		return reply(ctx, nil, nil)
 	}
 
 	debugLog.Printf("Hover word: %s", word)
 
-	// Look up the symbol
-	symbol := doc.SymbolTable.Lookup(word)
+	// Look up the symbol in the scope containing the cursor
+	symbol := doc.SymbolTable.LookupAt(word, int(params.Position.Line)+1, sourceColumn(doc.Lines, params.Position))
 	if symbol == nil {
 		// Check if it's a keyword
 		if hoverText := getKeywordHover(word); hoverText != "" {
@@ -59,21 +62,18 @@ func (s *Server) handleHover(ctx context.Context, reply jsonrpc2.Replier, req js
 	// Build hover content
 	hoverText := buildHoverText(symbol)
 
+	// The range is the word under the cursor
+	hoverRange := symbolRange(doc.Lines, symbol)
+	if word, ok := wordSpan(doc.Lines, int(params.Position.Line)+1, sourceColumn(doc.Lines, params.Position)); ok {
+		hoverRange = word.toRange(doc.Lines)
+	}
+
 	hover := protocol.Hover{
 		Contents: protocol.MarkupContent{
 			Kind:  protocol.Markdown,
 			Value: hoverText,
 		},
-		Range: &protocol.Range{
-			Start: protocol.Position{
-				Line:      uint32(symbol.Line - 1),
-				Character: uint32(symbol.Column),
-			},
-			End: protocol.Position{
-				Line:      uint32(symbol.Line - 1),
-				Character: uint32(symbol.Column + len(symbol.Name)),
-			},
-		},
+		Range: &hoverRange,
 	}
 
 	return reply(ctx, hover, nil)
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"ahoy"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// The protocol package predates LSP 3.17, so inlay hints are defined here
+const methodTextDocumentInlayHint = "textDocument/inlayHint"
+
+type inlayHintKind int
+
+const (
+	inlayHintKindType      inlayHintKind = 1
+	inlayHintKindParameter inlayHintKind = 2
+)
+
+type inlayHintParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Range        protocol.Range                  `json:"range"`
+}
+
+type inlayHint struct {
+	Position     protocol.Position `json:"position"`
+	Label        string            `json:"label"`
+	Kind         inlayHintKind     `json:"kind,omitempty"`
+	PaddingLeft  bool              `json:"paddingLeft,omitempty"`
+	PaddingRight bool              `json:"paddingRight,omitempty"`
+}
+
+// hintCategory is the kind of declaration a type hint belongs to
+type hintCategory int
+
+const (
+	hintVariableType hintCategory = iota + 1
+	hintLoopVariableType
+)
+
+// declarationKey identifies a declaration by its line and name
+type declarationKey struct {
+	line int
+	name string
+}
+
+func (s *Server) handleInlayHint(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params inlayHintParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil || doc.AST == nil || doc.SymbolTable == nil {
+		return reply(ctx, []inlayHint{}, nil)
+	}
+
+	config := s.getSettings().InlayHints
+	firstLine := int(params.Range.Start.Line) + 1
+	lastLine := int(params.Range.End.Line) + 1
+
+	hints := []inlayHint{}
+	if config.VariableTypes || config.LoopVariableTypes {
+		hints = append(hints, typeHints(doc, config, firstLine, lastLine)...)
+	}
+	if config.ParameterNames {
+		hints = append(hints, parameterNameHints(doc, firstLine, lastLine)...)
+	}
+
+	sort.Slice(hints, func(i, j int) bool {
+		if hints[i].Position.Line != hints[j].Position.Line {
+			return hints[i].Position.Line < hints[j].Position.Line
+		}
+		return hints[i].Position.Character < hints[j].Position.Character
+	})
+
+	return reply(ctx, hints, nil)
+}
+
+// typeHints shows the inferred type after the name of untyped variable and
+// constant declarations and of loop variables
+func typeHints(doc *Document, config inlayHintSettings, firstLine, lastLine int) []inlayHint {
+	categories := map[declarationKey]hintCategory{}
+	collectHintDeclarations(doc.AST, categories, 0)
+
+	hints := []inlayHint{}
+	for sym, refs := range doc.SymbolTable.References {
+		if sym.Kind != SymbolKindVariable && sym.Kind != SymbolKindConstant {
+			continue
+		}
+		if sym.Type == "" || sym.Type == "any" || sym.Type == "unknown" {
+			continue
+		}
+
+		for _, ref := range refs {
+			if !ref.IsDeclaration || ref.Line < firstLine || ref.Line > lastLine {
+				continue
+			}
+
+			switch categories[declarationKey{ref.Line, sym.Name}] {
+			case hintVariableType:
+				if !config.VariableTypes {
+					continue
+				}
+			case hintLoopVariableType:
+				if !config.LoopVariableTypes {
+					continue
+				}
+			default:
+				// Explicitly typed
+				continue
+			}
+
+			hints = append(hints, inlayHint{
+				Position: lspPosition(doc.Lines, ref.Line, ref.EndColumn),
+				Label:    ": " + sym.Type,
+				Kind:     inlayHintKindType,
+			})
+		}
+	}
+
+	return hints
+}
+
+// collectHintDeclarations records the declarations that may get a type
+// hint: those without a type annotation, and array loop variables
+func collectHintDeclarations(node *ahoy.ASTNode, categories map[declarationKey]hintCategory, depth int) {
+	if node == nil || depth > 1000 {
+		return
+	}
+
+	switch node.Type {
+	case ahoy.NODE_VARIABLE_DECLARATION, ahoy.NODE_ASSIGNMENT, ahoy.NODE_CONSTANT_DECLARATION:
+		if node.DataType == "" {
+			categories[declarationKey{node.Line, node.Value}] = hintVariableType
+		}
+	case ahoy.NODE_FOR_IN_ARRAY_LOOP:
+		if len(node.Children) > 0 && node.Children[0] != nil && node.Children[0].Type == ahoy.NODE_IDENTIFIER {
+			loopVar := node.Children[0]
+			categories[declarationKey{loopVar.Line, loopVar.Value}] = hintLoopVariableType
+		}
+	}
+
+	for _, child := range node.Children {
+		collectHintDeclarations(child, categories, depth+1)
+	}
+}
+
+// parameterNameHints labels the arguments of calls to user-defined
+// functions with the parameter they are passed to
+func parameterNameHints(doc *Document, firstLine, lastLine int) []inlayHint {
+	signatures := collectFunctionSignatures(doc.AST)
+	f := &formatter{
+		isFunction: func(name string) bool {
+			_, ok := signatures[name]
+			return ok || isBuiltinFunction(name)
+		},
+	}
+
+	// Arguments may continue on the lines after the callee, so calls are
+	// read from their whole statement, which several calls can share
+	lineStarts := lineOffsets(doc.Lines)
+	statements := map[int][]lexeme{}
+
+	hints := []inlayHint{}
+	for sym, refs := range doc.SymbolTable.References {
+		if sym.Kind != SymbolKindFunction {
+			continue
+		}
+		sig, ok := signatures[sym.Name]
+		if !ok || len(sig.Parameters) == 0 {
+			continue
+		}
+
+		for _, ref := range refs {
+			if ref.IsDeclaration || ref.Line < firstLine || ref.Line > lastLine || ref.Line > len(doc.Lines) {
+				continue
+			}
+
+			offset := lineStarts[ref.Line-1] + ref.Column
+			start, end := statementBounds(doc.Content, offset)
+			lexemes, ok := statements[start]
+			if !ok {
+				lexemes = statementLexemes(doc.Lines, lineStarts, start, end)
+				f.classifyPipes(lexemes)
+				statements[start] = lexemes
+			}
+
+			for i, arg := range callArguments(lexemes, offset) {
+				if i >= len(sig.Parameters) {
+					break
+				}
+				// Naming an argument after its parameter needs no hint
+				param := sig.Parameters[i]
+				if arg.text == param.Name {
+					continue
+				}
+
+				line := sort.Search(len(lineStarts), func(i int) bool { return lineStarts[i] > arg.offset })
+				hints = append(hints, inlayHint{
+					Position:     lspPosition(doc.Lines, line, arg.offset-lineStarts[line-1]),
+					Label:        param.Name + ":",
+					Kind:         inlayHintKindParameter,
+					PaddingRight: true,
+				})
+			}
+		}
+	}
+
+	return hints
+}
+
+// lineOffsets returns the byte offset in the document of each line
+func lineOffsets(lines []string) []int {
+	offsets := make([]int, len(lines))
+	offset := 0
+	for i, line := range lines {
+		offsets[i] = offset
+		offset += len(line) + 1
+	}
+	return offsets
+}
+
+// statementLexemes lexes the lines between the document offsets start and
+// end without their comments. Lexeme offsets are document offsets.
+func statementLexemes(lines []string, lineStarts []int, start, end int) []lexeme {
+	lexemes := []lexeme{}
+	first := sort.SearchInts(lineStarts, start)
+	for i := first; i < len(lines) && lineStarts[i] <= end; i++ {
+		code := lines[i]
+		if col := commentColumn(code); col >= 0 {
+			code = code[:col]
+		}
+		for _, lx := range lexCode(code) {
+			lx.offset += lineStarts[i]
+			lexemes = append(lexemes, lx)
+		}
+	}
+	return lexemes
+}
+
+// callArguments returns the first lexeme of each argument of the call whose
+// callee starts at offset. Nested calls and brackets are skipped over.
+func callArguments(lexemes []lexeme, offset int) []lexeme {
+	callee := -1
+	for i, lx := range lexemes {
+		if lx.offset == offset {
+			callee = i
+			break
+		}
+	}
+	if callee < 0 || callee+1 >= len(lexemes) || lexemes[callee+1].pipe != pipeOpen {
+		return nil
+	}
+
+	args := []lexeme{}
+	pipes := 0
+	brackets := 0
+	expectArg := true
+
+	for _, lx := range lexemes[callee+2:] {
+		if pipes == 0 && brackets == 0 {
+			if lx.pipe == pipeClose {
+				break
+			}
+			if lx.text == "," {
+				expectArg = true
+				continue
+			}
+		}
+
+		if expectArg {
+			args = append(args, lx)
+			expectArg = false
+		}
+
+		switch {
+		case lx.pipe == pipeOpen:
+			pipes++
+		case lx.pipe == pipeClose:
+			pipes--
+		case isOpenBracket(lx.text):
+			brackets++
+		case isCloseBracket(lx.text):
+			brackets--
+		}
+	}
+
+	return args
+}
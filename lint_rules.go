@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.lsp.dev/protocol"
+)
+
+// lintRule is a diagnostic code reported by the checks, with the severity it
+// has unless configured otherwise
+type lintRule struct {
+	Code        string
+	Severity    protocol.DiagnosticSeverity
+	Description string
+}
+
+// lintRules lists every rule. Codes are stable: they appear in diagnostics
+// and are used as keys in the lint settings.
+var lintRules = []lintRule{
+	{"program-position", protocol.DiagnosticSeverityError, "Program declaration must be on the first line"},
+	{"const-reassignment", protocol.DiagnosticSeverityError, "Assignment to a constant"},
+	{"variable-const-collision", protocol.DiagnosticSeverityError, "Variable declared with the name of a constant"},
+	{"const-redeclaration", protocol.DiagnosticSeverityError, "Constant declared twice"},
+	{"function-name-collision", protocol.DiagnosticSeverityError, "Variable, constant or type declared with the name of a function"},
+	{"const-method-call", protocol.DiagnosticSeverityError, "Method called on a constant"},
+	{"invalid-method", protocol.DiagnosticSeverityError, "Unknown method of a built-in type"},
+	{"void-return-violation", protocol.DiagnosticSeverityError, "Value returned from a void function"},
+	{"return-type-mismatch", protocol.DiagnosticSeverityError, "Returned value doesn't match the return type"},
+	{"missing-return", protocol.DiagnosticSeverityError, "Function with a return type never returns"},
+	{"enum-duplicate-member", protocol.DiagnosticSeverityError, "Enum member declared twice"},
+	{"enum-duplicate-declaration", protocol.DiagnosticSeverityError, "Enum declared twice"},
+	{"undefined-function", protocol.DiagnosticSeverityError, "Call to an unknown function"},
+	{"undeclared-identifier", protocol.DiagnosticSeverityError, "Use of an undeclared variable or constant"},
+	{"argument-count-mismatch", protocol.DiagnosticSeverityError, "Wrong number of call arguments"},
+	{"argument-type-mismatch", protocol.DiagnosticSeverityError, "Call argument of the wrong type"},
+	{"type-mismatch", protocol.DiagnosticSeverityError, "Value doesn't match the declared type"},
+	{"unused-suppression", protocol.DiagnosticSeverityWarning, "Suppression comment that matches no diagnostic"},
+}
+
+// lintCheck runs one check over a document. A check may report several rule
+// codes and is skipped when all of them are disabled.
+type lintCheck struct {
+	codes []string
+	run   func(doc *Document) []protocol.Diagnostic
+}
+
+var lintChecks = []lintCheck{
+	{[]string{"program-position"}, func(doc *Document) []protocol.Diagnostic {
+		if diagnostic := checkProgramDeclarationPosition(doc); diagnostic != nil {
+			return []protocol.Diagnostic{*diagnostic}
+		}
+		return nil
+	}},
+	{[]string{"const-reassignment", "variable-const-collision", "const-redeclaration"}, checkConstReassignment},
+	{[]string{"function-name-collision"}, checkFunctionNameCollisions},
+	{[]string{"const-method-call"}, checkConstMethodCalls},
+	{[]string{"invalid-method"}, checkInvalidMethodCalls},
+	{[]string{"void-return-violation", "return-type-mismatch", "missing-return"}, checkReturnTypeViolations},
+	{[]string{"enum-duplicate-member"}, checkEnumDuplicates},
+	{[]string{"enum-duplicate-declaration"}, checkEnumNameDuplicates},
+	{[]string{"undefined-function"}, checkUndefinedFunctions},
+	{[]string{"undeclared-identifier"}, checkUndeclaredIdentifiers},
+	{[]string{"argument-count-mismatch"}, checkFunctionCallArgumentCounts},
+	{[]string{"argument-type-mismatch"}, checkFunctionCallArgumentTypes},
+	{[]string{"type-mismatch"}, checkTypeMismatches},
+}
+
+// lintSettings configures the rules by code. Rules that aren't listed keep
+// their defaults.
+type lintSettings struct {
+	Rules map[string]ruleSetting `json:"rules"`
+}
+
+// UnmarshalJSON merges the rules in data into l. Each rule is merged field
+// by field, so a later source of settings can change the severity of a
+// rule an earlier one disabled without enabling it again.
+func (l *lintSettings) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Rules map[string]json.RawMessage `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if l.Rules == nil {
+		l.Rules = make(map[string]ruleSetting, len(raw.Rules))
+	}
+	for code, value := range raw.Rules {
+		var rule ruleSetting
+		if err := json.Unmarshal(value, &rule); err != nil {
+			return err
+		}
+		l.Rules[code] = l.Rules[code].merge(rule)
+	}
+	return nil
+}
+
+// ruleSetting overrides one rule. In JSON it is either an object or a
+// severity name, which also enables the rule unless it is "off":
+//
+//	"undeclared-identifier": "warning"
+//	"missing-return": {"enabled": false}
+type ruleSetting struct {
+	Enabled  *bool  `json:"enabled,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+func (r *ruleSetting) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		enabled := name != "off"
+		*r = ruleSetting{Enabled: &enabled}
+		if enabled {
+			r.Severity = name
+		}
+		return r.validate()
+	}
+
+	type plain ruleSetting
+	var setting plain
+	if err := json.Unmarshal(data, &setting); err != nil {
+		return err
+	}
+	*r = ruleSetting(setting)
+	return r.validate()
+}
+
+// merge returns r with the fields set in override replaced
+func (r ruleSetting) merge(override ruleSetting) ruleSetting {
+	if override.Enabled != nil {
+		r.Enabled = override.Enabled
+	}
+	if override.Severity != "" {
+		r.Severity = override.Severity
+	}
+	return r
+}
+
+func (r ruleSetting) validate() error {
+	if _, ok := parseSeverity(r.Severity); r.Severity != "" && !ok {
+		return fmt.Errorf("unknown severity %q", r.Severity)
+	}
+	return nil
+}
+
+// parseSeverity maps a severity name to its LSP value
+func parseSeverity(name string) (protocol.DiagnosticSeverity, bool) {
+	switch strings.ToLower(name) {
+	case "error":
+		return protocol.DiagnosticSeverityError, true
+	case "warning":
+		return protocol.DiagnosticSeverityWarning, true
+	case "information", "info":
+		return protocol.DiagnosticSeverityInformation, true
+	case "hint":
+		return protocol.DiagnosticSeverityHint, true
+	}
+	return 0, false
+}
+
+// clone copies the settings so they can be updated without affecting
+// readers of the original
+func (l lintSettings) clone() lintSettings {
+	rules := make(map[string]ruleSetting, len(l.Rules))
+	for code, rule := range l.Rules {
+		rules[code] = rule
+	}
+	return lintSettings{Rules: rules}
+}
+
+// severity returns the configured severity of a rule and whether it is
+// enabled. Codes without a rule, like parse errors, are always reported.
+func (l lintSettings) severity(code string, fallback protocol.DiagnosticSeverity) (protocol.DiagnosticSeverity, bool) {
+	severity := fallback
+	for _, rule := range lintRules {
+		if rule.Code == code {
+			severity = rule.Severity
+			break
+		}
+	}
+
+	setting, ok := l.Rules[code]
+	if !ok {
+		return severity, true
+	}
+	if setting.Enabled != nil && !*setting.Enabled {
+		return severity, false
+	}
+	if configured, ok := parseSeverity(setting.Severity); ok {
+		severity = configured
+	}
+	return severity, true
+}
+
+// enabled reports whether any of the codes is enabled
+func (l lintSettings) enabled(codes []string) bool {
+	for _, code := range codes {
+		if _, on := l.severity(code, protocol.DiagnosticSeverityError); on {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRules drops the diagnostics of disabled rules and sets the configured
+// severity on the rest
+func (l lintSettings) applyRules(diagnostics []protocol.Diagnostic) []protocol.Diagnostic {
+	result := diagnostics[:0]
+	for _, diagnostic := range diagnostics {
+		code, _ := diagnostic.Code.(string)
+		severity, on := l.severity(code, diagnostic.Severity)
+		if !on {
+			continue
+		}
+		diagnostic.Severity = severity
+		result = append(result, diagnostic)
+	}
+	return result
+}
@@ -2,24 +2,86 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/debug"
+	"syscall"
 	"time"
-
-	"go.lsp.dev/jsonrpc2"
 )
 
 var debugLog *log.Logger
 
+// version is reported to clients and by the command line tools
+const version = "0.1.0"
+
+// defaultMemoryLimitMB is the default soft memory limit of the runtime
+const defaultMemoryLimitMB = 500
+
 func init() {
 	// Initialize debug logger to stderr
 	debugLog = log.New(os.Stderr, "[ahoy-lsp] ", log.LstdFlags)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	os.Exit(run(os.Args[1:]))
+}
+
+// run starts the language server on the transport chosen by the flags and
+// returns the exit code
+func run(args []string) int {
+	flags := flag.NewFlagSet("ahoy-lsp", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ahoy-lsp [flags]")
+		fmt.Fprintln(os.Stderr, "       ahoy-lsp check [flags] [path ...]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Runs the Ahoy language server, over stdin and stdout unless another")
+		fmt.Fprintln(os.Stderr, "transport is given. Run 'ahoy-lsp check -help' for the batch checker.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	flags.Bool("stdio", false, "communicate over stdin and stdout (the default)")
+	tcp := flags.String("listen", "", "accept clients on a TCP address such as 127.0.0.1:9257")
+	unix := flags.String("unix", "", "accept clients on a Unix domain socket at this path")
+	pipe := flags.String("pipe", "", "connect to a socket or named pipe created by the editor")
+	single := flags.Bool("single", false, "with -listen or -unix, serve one client and exit")
+	flags.Int("clientProcessId", 0, "process ID of the editor; accepted for compatibility and ignored")
+	showVersion := flags.Bool("version", false, "print the version and exit")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "ahoy-lsp: unexpected argument %q\n", flags.Arg(0))
+		return 2
+	}
+	transports := 0
+	for _, address := range []string{*tcp, *unix, *pipe} {
+		if address != "" {
+			transports++
+		}
+	}
+	if transports > 1 {
+		fmt.Fprintln(os.Stderr, "ahoy-lsp: -listen, -unix and -pipe are mutually exclusive")
+		return 2
+	}
+
+	if *showVersion {
+		fmt.Println("ahoy-lsp", version)
+		return 0
+	}
+
 	debugLog.Println("Starting Ahoy Language Server")
 
 	// Set aggressive garbage collection to prevent memory buildup
@@ -27,39 +89,43 @@ func main() {
 
 	// Set memory limit to prevent system crashes
 	// This will cause the runtime to GC more aggressively as we approach the limit
-	debug.SetMemoryLimit(500 * 1024 * 1024) // 500MB limit
+	// The limit is configurable and updated with the settings
+	debug.SetMemoryLimit(defaultMemoryLimitMB * 1024 * 1024)
 
 	// Start memory monitor goroutine
 	go monitorMemory()
 
-	ctx := context.Background()
-
-	// Create stdio stream for communication with editor
-	stream := jsonrpc2.NewStream(&stdrwc{})
-	conn := jsonrpc2.NewConn(stream)
-
-	// Create server
-	server := NewServer(conn)
-	debugLog.Println("Server created successfully")
-
-	// Start JSON-RPC handler
-	handler := jsonrpc2.ReplyHandler(server.Handle)
-	conn.Go(ctx, handler)
-	debugLog.Println("Handler started, waiting for requests")
-
-	// Wait for connection to close
-	<-conn.Done()
-
-	debugLog.Println("Connection closed")
+	// Stopping the process closes the connections and listeners, so Unix
+	// sockets are cleaned up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch {
+	case *tcp != "":
+		err = listenAndServe(ctx, "tcp", *tcp, *single)
+	case *unix != "":
+		err = listenAndServe(ctx, "unix", *unix, *single)
+	case *pipe != "":
+		err = dialAndServe(ctx, *pipe)
+	default:
+		// Create stdio stream for communication with editor
+		err = serveConn(ctx, &stdrwc{})
+	}
 
 	// Check for errors
-	if err := conn.Err(); err != nil {
+	if errors.Is(err, errExitWithoutShutdown) {
+		debugLog.Println("Exiting without a shutdown request")
+		return 1
+	}
+	if err != nil {
 		debugLog.Printf("LSP connection error: %v\n", err)
 		fmt.Fprintf(os.Stderr, "LSP connection error: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
 	debugLog.Println("Shutting down cleanly")
+	return 0
 }
 
 // monitorMemory periodically logs memory usage and forces GC if needed
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"strings"
+
+	"ahoy"
+
+	"go.lsp.dev/protocol"
+)
+
+// nodeSpan is the source extent of an AST node. The parser only records the
+// line a node starts on, so spans are reconstructed from token positions.
+type nodeSpan struct {
+	StartLine   int // 1-based, like ASTNode.Line
+	StartColumn int // 0-based
+	EndLine     int // 1-based
+	EndColumn   int // 0-based, exclusive
+}
+
+// contains reports whether the (1-based) line and column fall inside the span
+func (s nodeSpan) contains(line, column int) bool {
+	if line < s.StartLine || line > s.EndLine {
+		return false
+	}
+	if line == s.StartLine && column < s.StartColumn {
+		return false
+	}
+	if line == s.EndLine && column > s.EndColumn {
+		return false
+	}
+	return true
+}
+
+// encloses reports whether other lies entirely within the span
+func (s nodeSpan) encloses(other nodeSpan) bool {
+	return s.contains(other.StartLine, other.StartColumn) && s.contains(other.EndLine, other.EndColumn)
+}
+
+func (s nodeSpan) union(other nodeSpan) nodeSpan {
+	if other.StartLine < s.StartLine || (other.StartLine == s.StartLine && other.StartColumn < s.StartColumn) {
+		s.StartLine, s.StartColumn = other.StartLine, other.StartColumn
+	}
+	if other.EndLine > s.EndLine || (other.EndLine == s.EndLine && other.EndColumn > s.EndColumn) {
+		s.EndLine, s.EndColumn = other.EndLine, other.EndColumn
+	}
+	return s
+}
+
+// toRange converts the span to an LSP range over the given source lines
+func (s nodeSpan) toRange(lines []string) protocol.Range {
+	return lspRange(lines, s.StartLine, s.StartColumn, s.EndLine, s.EndColumn)
+}
+
+// computeNodeSpans finds the extent of every AST node. A node covers its
+// own token, its children, and the punctuation that delimits it (quotes,
+// brackets, the pipes of a call). Nodes on a line of their own are
+// statements and cover whole lines, excluding trailing comments.
+//
+// The token each node was matched to is returned too, so the symbol table
+// records the same occurrence of a name as the node's span.
+func computeNodeSpans(ast *ahoy.ASTNode, tokens []ahoy.Token, lines []string) (map[*ahoy.ASTNode]nodeSpan, map[*ahoy.ASTNode]*tokenSpan) {
+	b := &spanBuilder{
+		lines:      lines,
+		tokens:     newTokenIndex(tokens, lines),
+		spans:      make(map[*ahoy.ASTNode]nodeSpan),
+		nodeTokens: make(map[*ahoy.ASTNode]*tokenSpan),
+	}
+
+	if ast != nil {
+		var from nodeSpan
+		for _, child := range ast.Children {
+			if s, ok := b.span(child, ast, 0, from); ok {
+				from = s
+			}
+		}
+	}
+
+	return b.spans, b.nodeTokens
+}
+
+type spanBuilder struct {
+	lines      []string
+	tokens     *tokenIndex
+	spans      map[*ahoy.ASTNode]nodeSpan
+	nodeTokens map[*ahoy.ASTNode]*tokenSpan
+}
+
+// span computes the extent of node. after is the span of the source just
+// before it, its previous sibling or its parent's own token, and tokens are
+// matched after its end. The operator of a binary operation sits between
+// its operands, so it is matched after the left one.
+func (b *spanBuilder) span(node, parent *ahoy.ASTNode, depth int, after nodeSpan) (nodeSpan, bool) {
+	if node == nil || depth > 1000 {
+		return nodeSpan{}, false
+	}
+
+	var result nodeSpan
+	ok := false
+	add := func(s nodeSpan) {
+		if ok {
+			result = result.union(s)
+		} else {
+			result, ok = s, true
+		}
+		after = s
+	}
+	claimOwn := func() {
+		if tok := b.claim(node, after); tok != nil {
+			b.nodeTokens[node] = tok
+			add(nodeSpan{tok.Line, tok.Column, tok.Line, tok.EndColumn})
+		}
+	}
+
+	infix := node.Type == ahoy.NODE_BINARY_OP
+	if !infix {
+		claimOwn()
+	}
+
+	children := node.Children
+	if node.DefaultValue != nil {
+		children = append(append([]*ahoy.ASTNode{}, children...), node.DefaultValue)
+	}
+	for i, child := range children {
+		if childSpan, childOK := b.span(child, node, depth+1, after); childOK {
+			add(childSpan)
+		}
+		if infix && i == 0 {
+			claimOwn()
+		}
+	}
+	if infix && len(children) == 0 {
+		claimOwn()
+	}
+
+	if ok {
+		result = b.widen(node, result)
+	}
+
+	if b.isStatement(node, parent, result, ok) {
+		statement := b.lineSpan(node.Line, subtreeEndLine(node, 0))
+		if ok {
+			result = statement.union(result)
+		} else {
+			result, ok = statement, true
+		}
+	}
+
+	if ok {
+		b.spans[node] = result
+	}
+	return result, ok
+}
+
+// claim matches node to its token, the first occurrence of its value on its
+// line after the end of the after span
+func (b *spanBuilder) claim(node *ahoy.ASTNode, after nodeSpan) *tokenSpan {
+	from := 0
+	if after.EndLine == node.Line {
+		from = after.EndColumn
+	}
+
+	switch node.Type {
+	case ahoy.NODE_STRING, ahoy.NODE_F_STRING, ahoy.NODE_CHAR:
+		return b.tokens.claimToken(node.Line, node.Value, true, from)
+	}
+	return b.tokens.claimToken(node.Line, node.Value, false, from)
+}
+
+// isStatement reports whether node is a statement: a direct child of a
+// block, or a node that starts its own line
+func (b *spanBuilder) isStatement(node, parent *ahoy.ASTNode, s nodeSpan, ok bool) bool {
+	if node.Line <= 0 || node.Line > len(b.lines) {
+		return false
+	}
+	if parent.Type == ahoy.NODE_PROGRAM || parent.Type == ahoy.NODE_BLOCK {
+		return true
+	}
+	if parent.Line == node.Line {
+		return false
+	}
+	return !ok || (s.StartLine == node.Line && s.StartColumn == b.lineSpan(node.Line, node.Line).StartColumn)
+}
+
+// widen extends a span over the delimiters that belong to the node
+func (b *spanBuilder) widen(node *ahoy.ASTNode, s nodeSpan) nodeSpan {
+	switch node.Type {
+	case ahoy.NODE_STRING, ahoy.NODE_F_STRING, ahoy.NODE_CHAR:
+		startLine := b.line(s.StartLine)
+		endLine := b.line(s.EndLine)
+		if s.StartColumn > 0 && isQuote(startLine[s.StartColumn-1]) {
+			s.StartColumn--
+			if s.StartColumn > 0 && startLine[s.StartColumn-1] == 'f' {
+				s.StartColumn--
+			}
+		}
+		if s.EndColumn < len(endLine) && isQuote(endLine[s.EndColumn]) {
+			s.EndColumn++
+		}
+
+	case ahoy.NODE_ARRAY_LITERAL, ahoy.NODE_DICT_LITERAL:
+		if b.charBefore(s.StartLine, s.StartColumn, "[{<") {
+			s.StartColumn = b.skipBack(s.StartLine, s.StartColumn) - 1
+		}
+		if b.charAfter(s.EndLine, s.EndColumn, "]}>") {
+			s.EndColumn = b.skipForward(s.EndLine, s.EndColumn) + 1
+		}
+
+	case ahoy.NODE_ARRAY_ACCESS:
+		if b.charAfter(s.EndLine, s.EndColumn, "]}>") {
+			s.EndColumn = b.skipForward(s.EndLine, s.EndColumn) + 1
+		}
+
+	case ahoy.NODE_CALL, ahoy.NODE_METHOD_CALL:
+		// Without arguments both pipes follow the callee
+		pipes := 1
+		if len(node.Children) == 0 {
+			pipes = 2
+		}
+		for ; pipes > 0 && b.charAfter(s.EndLine, s.EndColumn, "|"); pipes-- {
+			s.EndColumn = b.skipForward(s.EndLine, s.EndColumn) + 1
+		}
+	}
+
+	return s
+}
+
+// lineSpan covers the code on lines first..last, without indentation or
+// trailing comments
+func (b *spanBuilder) lineSpan(first, last int) nodeSpan {
+	if last > len(b.lines) {
+		last = len(b.lines)
+	}
+
+	startText := b.line(first)
+	endText := b.line(last)
+	if col := commentColumn(endText); col >= 0 {
+		endText = endText[:col]
+	}
+
+	return nodeSpan{
+		StartLine:   first,
+		StartColumn: len(startText) - len(strings.TrimLeft(startText, " \t")),
+		EndLine:     last,
+		EndColumn:   len(strings.TrimRight(endText, " \t\r")),
+	}
+}
+
+func (b *spanBuilder) line(line int) string {
+	if line <= 0 || line > len(b.lines) {
+		return ""
+	}
+	return b.lines[line-1]
+}
+
+// charBefore reports whether the last non-blank character before column is
+// one of chars
+func (b *spanBuilder) charBefore(line, column int, chars string) bool {
+	text := b.line(line)
+	col := b.skipBack(line, column)
+	return col > 0 && col <= len(text) && strings.IndexByte(chars, text[col-1]) >= 0
+}
+
+// charAfter reports whether the first non-blank character at or after
+// column is one of chars
+func (b *spanBuilder) charAfter(line, column int, chars string) bool {
+	text := b.line(line)
+	col := b.skipForward(line, column)
+	return col >= 0 && col < len(text) && strings.IndexByte(chars, text[col]) >= 0
+}
+
+// skipBack returns the column just after the last non-blank character
+// before column
+func (b *spanBuilder) skipBack(line, column int) int {
+	text := b.line(line)
+	for column > 0 && column <= len(text) && (text[column-1] == ' ' || text[column-1] == '\t') {
+		column--
+	}
+	return column
+}
+
+// skipForward returns the column of the first non-blank character at or
+// after column
+func (b *spanBuilder) skipForward(line, column int) int {
+	text := b.line(line)
+	for column < len(text) && (text[column] == ' ' || text[column] == '\t') {
+		column++
+	}
+	return column
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"ahoy"
+)
+
+func TestComputeNodeSpansBinaryOperators(t *testing.T) {
+	// s: a + b + c parses as (a + b) + c, whose root operator is the second +
+	lines := []string{"s: a + b + c"}
+	tokens := []ahoy.Token{
+		{Value: "s", Line: 1, Column: 1},
+		{Value: ":", Line: 1, Column: 2},
+		{Value: "a", Line: 1, Column: 4},
+		{Value: "+", Line: 1, Column: 6},
+		{Value: "b", Line: 1, Column: 8},
+		{Value: "+", Line: 1, Column: 10},
+		{Value: "c", Line: 1, Column: 12},
+	}
+	inner := &ahoy.ASTNode{Type: ahoy.NODE_BINARY_OP, Value: "+", Line: 1, Children: []*ahoy.ASTNode{
+		{Type: ahoy.NODE_IDENTIFIER, Value: "a", Line: 1},
+		{Type: ahoy.NODE_IDENTIFIER, Value: "b", Line: 1},
+	}}
+	root := &ahoy.ASTNode{Type: ahoy.NODE_BINARY_OP, Value: "+", Line: 1, Children: []*ahoy.ASTNode{
+		inner,
+		{Type: ahoy.NODE_IDENTIFIER, Value: "c", Line: 1},
+	}}
+	assignment := &ahoy.ASTNode{Type: ahoy.NODE_ASSIGNMENT, Value: "s", Line: 1, Children: []*ahoy.ASTNode{root}}
+	ast := &ahoy.ASTNode{Type: ahoy.NODE_PROGRAM, Children: []*ahoy.ASTNode{assignment}}
+
+	spans, nodeTokens := computeNodeSpans(ast, tokens, lines)
+
+	tests := []struct {
+		name       string
+		node       *ahoy.ASTNode
+		span       nodeSpan
+		operatorAt int
+	}{
+		{"inner operation", inner, nodeSpan{1, 3, 1, 8}, 5},
+		{"root operation", root, nodeSpan{1, 3, 1, 12}, 9},
+	}
+
+	for _, tt := range tests {
+		if got := spans[tt.node]; got != tt.span {
+			t.Errorf("%s: span %+v, want %+v", tt.name, got, tt.span)
+		}
+		if tok := nodeTokens[tt.node]; tok == nil || tok.Column != tt.operatorAt {
+			t.Errorf("%s: operator matched %+v, want column %d", tt.name, tok, tt.operatorAt)
+		}
+	}
+
+	if got, want := spans[assignment], (nodeSpan{1, 0, 1, 12}); got != want {
+		t.Errorf("statement span %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// projectConfigFile is the project configuration looked for at the root of
+// each workspace folder. It holds the same options as initializationOptions.
+const projectConfigFile = "ahoy-lsp.json"
+
+// loadProjectSettings reads the project file of each folder, in folder order
+func loadProjectSettings(folders []string) []json.RawMessage {
+	layers := []json.RawMessage{}
+	for _, folder := range folders {
+		path := filepath.Join(folder, projectConfigFile)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				debugLog.Printf("Reading %s failed: %v", path, err)
+			}
+			continue
+		}
+		if !json.Valid(data) {
+			debugLog.Printf("Ignoring %s: not valid JSON", path)
+			continue
+		}
+
+		debugLog.Printf("Loaded project configuration %s", path)
+		layers = append(layers, data)
+	}
+	return layers
+}
+
+// reloadSettings rereads the project files and updates the settings
+func (s *Server) reloadSettings() {
+	folders := s.workspace.folders()
+	project := loadProjectSettings(folders)
+
+	s.mu.Lock()
+	s.projectSettings = project
+	s.mu.Unlock()
+
+	s.updateSettings(folders)
+}
+
+// File watcher registration IDs. Project files are watched separately, as
+// their watchers change with the workspace folders.
+const (
+	sourceWatcherID  = "ahoy-lsp-watched-files"
+	projectWatcherID = "ahoy-lsp-project-files"
+)
+
+// watchFiles asks the client to report changes to .ahoy files, which keeps
+// the workspace index in step with edits made outside the editor, and to
+// project files. Like fetchConfiguration, it must not be called from a
+// handler.
+func (s *Server) watchFiles() {
+	params := protocol.RegistrationParams{
+		Registrations: []protocol.Registration{{
+			ID:     sourceWatcherID,
+			Method: protocol.MethodWorkspaceDidChangeWatchedFiles,
+			RegisterOptions: protocol.DidChangeWatchedFilesRegistrationOptions{
+				Watchers: []protocol.FileSystemWatcher{{GlobPattern: "**/*.ahoy"}},
+			},
+		}},
+	}
+
+	if _, err := s.conn.Call(context.Background(), protocol.MethodClientRegisterCapability, params, nil); err != nil {
+		debugLog.Printf("Registering the file watchers failed: %v", err)
+	}
+	s.watchProjectFiles()
+}
+
+// watchProjectFiles (re)registers a watcher for the project file at the
+// root of each workspace folder, the only ones that are read. Like
+// watchFiles, it must not be called from a handler.
+func (s *Server) watchProjectFiles() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	if s.watchingProjects {
+		params := protocol.UnregistrationParams{
+			Unregisterations: []protocol.Unregistration{{
+				ID:     projectWatcherID,
+				Method: protocol.MethodWorkspaceDidChangeWatchedFiles,
+			}},
+		}
+		if _, err := s.conn.Call(context.Background(), protocol.MethodClientUnregisterCapability, params, nil); err != nil {
+			debugLog.Printf("Unregistering the project file watchers failed: %v", err)
+		}
+		s.watchingProjects = false
+	}
+
+	// Read under watchMu, so the last folder change is the one registered
+	folders := s.workspace.folders()
+	if len(folders) == 0 {
+		return
+	}
+	watchers := make([]protocol.FileSystemWatcher, len(folders))
+	for i, folder := range folders {
+		watchers[i] = protocol.FileSystemWatcher{GlobPattern: escapeGlob(filepath.ToSlash(filepath.Join(folder, projectConfigFile)))}
+	}
+
+	params := protocol.RegistrationParams{
+		Registrations: []protocol.Registration{{
+			ID:              projectWatcherID,
+			Method:          protocol.MethodWorkspaceDidChangeWatchedFiles,
+			RegisterOptions: protocol.DidChangeWatchedFilesRegistrationOptions{Watchers: watchers},
+		}},
+	}
+	if _, err := s.conn.Call(context.Background(), protocol.MethodClientRegisterCapability, params, nil); err != nil {
+		debugLog.Printf("Registering the project file watchers failed: %v", err)
+		return
+	}
+	s.watchingProjects = true
+}
+
+// escapeGlob makes a path match itself as a glob pattern by putting glob
+// syntax characters in brackets
+func escapeGlob(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', ']', '{', '}':
+			b.WriteByte('[')
+			b.WriteRune(r)
+			b.WriteByte(']')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isProjectFile reports whether path is the project file of a workspace
+// folder
+func isProjectFile(path string, folders []string) bool {
+	if filepath.Base(path) != projectConfigFile {
+		return false
+	}
+	dir := filepath.Dir(path)
+	for _, folder := range folders {
+		if filepath.Clean(folder) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleInitialized(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	s.mu.RLock()
+	watch, pull := s.canWatchFiles, s.pullConfiguration
+	s.mu.RUnlock()
+
+	go func() {
+		if watch {
+			s.watchFiles()
+		}
+		if pull {
+			s.fetchConfiguration()
+		}
+	}()
+
+	return reply(ctx, nil, nil)
+}
+
+func (s *Server) handleDidChangeWatchedFiles(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.DidChangeWatchedFilesParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	folders := s.workspace.folders()
+	configChanged := false
+	for _, change := range params.Changes {
+		if change == nil {
+			continue
+		}
+
+		path := change.URI.Filename()
+		switch {
+		case isProjectFile(path, folders):
+			configChanged = true
+		case filepath.Ext(path) == ".ahoy":
+			go s.workspace.fileChanged(change.URI, change.Type == protocol.FileChangeTypeDeleted)
+		}
+	}
+
+	if configChanged {
+		debugLog.Printf("Project configuration changed")
+		s.reloadSettings()
+		s.refreshDiagnostics(ctx)
+	}
+
+	return reply(ctx, nil, nil)
+}
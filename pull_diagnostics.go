@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// The protocol package predates LSP 3.17, so pull diagnostics are defined here
+const (
+	methodTextDocumentDiagnostic     = "textDocument/diagnostic"
+	methodWorkspaceDiagnostic        = "workspace/diagnostic"
+	methodWorkspaceDiagnosticRefresh = "workspace/diagnostic/refresh"
+)
+
+const (
+	diagnosticReportFull      = "full"
+	diagnosticReportUnchanged = "unchanged"
+)
+
+type diagnosticOptions struct {
+	Identifier            string `json:"identifier,omitempty"`
+	InterFileDependencies bool   `json:"interFileDependencies"`
+	WorkspaceDiagnostics  bool   `json:"workspaceDiagnostics"`
+}
+
+type documentDiagnosticParams struct {
+	TextDocument     protocol.TextDocumentIdentifier `json:"textDocument"`
+	Identifier       string                          `json:"identifier,omitempty"`
+	PreviousResultID string                          `json:"previousResultId,omitempty"`
+}
+
+type previousResultID struct {
+	URI   uri.URI `json:"uri"`
+	Value string  `json:"value"`
+}
+
+type workspaceDiagnosticParams struct {
+	Identifier        string             `json:"identifier,omitempty"`
+	PreviousResultIDs []previousResultID `json:"previousResultIds"`
+}
+
+type fullDiagnosticReport struct {
+	Kind     string                `json:"kind"`
+	ResultID string                `json:"resultId,omitempty"`
+	Items    []protocol.Diagnostic `json:"items"`
+}
+
+type unchangedDiagnosticReport struct {
+	Kind     string `json:"kind"`
+	ResultID string `json:"resultId"`
+}
+
+// workspaceDiagnosticItem is a document report in a workspace/diagnostic
+// result. Version is null for files that aren't open, and Items is only set
+// in full reports.
+type workspaceDiagnosticItem struct {
+	Kind     string                 `json:"kind"`
+	ResultID string                 `json:"resultId,omitempty"`
+	URI      uri.URI                `json:"uri"`
+	Version  *int32                 `json:"version"`
+	Items    *[]protocol.Diagnostic `json:"items,omitempty"`
+}
+
+type workspaceDiagnosticReport struct {
+	Items []workspaceDiagnosticItem `json:"items"`
+}
+
+// clientPullsDiagnostics reports whether the client declared support for
+// textDocument/diagnostic in its initialize capabilities
+func clientPullsDiagnostics(raw json.RawMessage) bool {
+	var params struct {
+		Capabilities struct {
+			TextDocument struct {
+				Diagnostic *json.RawMessage `json:"diagnostic"`
+			} `json:"textDocument"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return false
+	}
+	return params.Capabilities.TextDocument.Diagnostic != nil
+}
+
+// pushesDiagnostics reports whether diagnostics are sent with
+// textDocument/publishDiagnostics rather than pulled by the client
+func (s *Server) pushesDiagnostics() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.pullDiagnostics
+}
+
+// refreshDiagnostics brings the client's diagnostics up to date after the
+// lint settings change: open documents are republished, or a client that
+// pulls diagnostics is asked to pull them again
+func (s *Server) refreshDiagnostics(ctx context.Context) {
+	if !s.pushesDiagnostics() {
+		go func() {
+			if _, err := s.conn.Call(context.Background(), methodWorkspaceDiagnosticRefresh, nil, nil); err != nil {
+				debugLog.Printf("Diagnostic refresh failed: %v", err)
+			}
+		}()
+		return
+	}
+
+	s.mu.RLock()
+	docs := make([]*Document, 0, len(s.documents))
+	for _, doc := range s.documents {
+		docs = append(docs, doc)
+	}
+	s.mu.RUnlock()
+
+	for _, doc := range docs {
+		s.publishDiagnostics(ctx, doc)
+	}
+}
+
+// Diagnostics only depend on a document's text and the settings, so a
+// result ID names those inputs: the settings version and either the editor
+// version of an open document or the index revision of a snapshot. A
+// client that already has the ID is answered unchanged without computing
+// the diagnostics again.
+
+// editorResultID is the result ID of an open document's diagnostics
+func editorResultID(settingsVersion uint64, version int32) string {
+	return fmt.Sprintf("%d.v%d", settingsVersion, version)
+}
+
+// indexResultID is the result ID of an indexed snapshot's diagnostics
+func indexResultID(settingsVersion uint64, revision uint64) string {
+	return fmt.Sprintf("%d.r%d", settingsVersion, revision)
+}
+
+func (s *Server) handleDocumentDiagnostic(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params documentDiagnosticParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	config, settingsVersion := s.getVersionedSettings()
+	unchanged := func(resultID string) error {
+		return reply(ctx, unchangedDiagnosticReport{Kind: diagnosticReportUnchanged, ResultID: resultID}, nil)
+	}
+
+	// Open documents are checked at their current text; other files are
+	// checked as indexed from disk
+	var doc *Document
+	var resultID string
+	if worker := s.getWorker(params.TextDocument.URI); worker != nil {
+		worker.mu.Lock()
+		version := worker.version
+		worker.mu.Unlock()
+		if id := editorResultID(settingsVersion, version); id == params.PreviousResultID {
+			return unchanged(id)
+		}
+
+		if doc = s.liveDocument(ctx, params.TextDocument.URI); doc != nil {
+			resultID = editorResultID(settingsVersion, doc.Version)
+		}
+	}
+	if doc == nil {
+		indexed, revision := s.workspace.snapshot(params.TextDocument.URI)
+		if indexed == nil {
+			return reply(ctx, fullDiagnosticReport{Kind: diagnosticReportFull, Items: []protocol.Diagnostic{}}, nil)
+		}
+		resultID = indexResultID(settingsVersion, revision)
+		if resultID == params.PreviousResultID {
+			return unchanged(resultID)
+		}
+		doc = indexed
+	}
+
+	diagnostics := computeDiagnostics(doc, config.Lint)
+
+	return reply(ctx, fullDiagnosticReport{
+		Kind:     diagnosticReportFull,
+		ResultID: resultID,
+		Items:    diagnostics,
+	}, nil)
+}
+
+func (s *Server) handleWorkspaceDiagnostic(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params workspaceDiagnosticParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	previous := make(map[uri.URI]string, len(params.PreviousResultIDs))
+	for _, id := range params.PreviousResultIDs {
+		previous[id.URI] = id.Value
+	}
+
+	config, settingsVersion := s.getVersionedSettings()
+	report := workspaceDiagnosticReport{Items: []workspaceDiagnosticItem{}}
+	for _, indexed := range s.workspace.indexedDocuments() {
+		if ctx.Err() != nil {
+			return reply(ctx, nil, ctx.Err())
+		}
+
+		doc := indexed.doc
+		item := workspaceDiagnosticItem{
+			URI:      doc.URI,
+			ResultID: indexResultID(settingsVersion, indexed.revision),
+		}
+		if s.getDocument(doc.URI) != nil {
+			version := doc.Version
+			item.Version = &version
+		}
+
+		if item.ResultID == previous[doc.URI] {
+			item.Kind = diagnosticReportUnchanged
+		} else {
+			diagnostics := computeDiagnostics(doc, config.Lint)
+			item.Kind = diagnosticReportFull
+			item.Items = &diagnostics
+		}
+
+		report.Items = append(report.Items, item)
+	}
+
+	return reply(ctx, report, nil)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+func (s *Server) handleReferences(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.ReferenceParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil || doc.SymbolTable == nil {
+		return reply(ctx, []protocol.Location{}, nil)
+	}
+
+	// Resolve the occurrence under the cursor to the symbol it binds to
+	symbol, _ := doc.SymbolTable.ReferenceAt(int(params.Position.Line)+1, sourceColumn(doc.Lines, params.Position))
+	if symbol == nil {
+		return reply(ctx, []protocol.Location{}, nil)
+	}
+
+	refs := doc.SymbolTable.FindReferences(symbol, params.Context.IncludeDeclaration)
+
+	locations := make([]protocol.Location, 0, len(refs))
+	for _, ref := range refs {
+		locations = append(locations, protocol.Location{
+			URI:   params.TextDocument.URI,
+			Range: referenceToRange(doc.Lines, ref),
+		})
+	}
+
+	return reply(ctx, locations, nil)
+}
+
+// referenceToRange converts a recorded reference to an LSP range
+func referenceToRange(lines []string, ref Reference) protocol.Range {
+	return lspRange(lines, ref.Line, ref.Column, ref.Line, ref.EndColumn)
+}
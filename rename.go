@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+func (s *Server) handlePrepareRename(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.PrepareRenameParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.liveDocument(ctx, params.TextDocument.URI)
+	if doc == nil || doc.SymbolTable == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	symbol, ref := doc.SymbolTable.ReferenceAt(int(params.Position.Line)+1, sourceColumn(doc.Lines, params.Position))
+	if symbol == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	if err := checkRenamable(symbol); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	return reply(ctx, referenceToRange(doc.Lines, *ref), nil)
+}
+
+func (s *Server) handleRename(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.RenameParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	// Edits are computed against the text the editor has now, and are
+	// versioned so a client that has moved on rejects them
+	doc := s.liveDocument(ctx, params.TextDocument.URI)
+	if doc == nil || doc.SymbolTable == nil {
+		return reply(ctx, nil, fmt.Errorf("document not found"))
+	}
+
+	symbol, _ := doc.SymbolTable.ReferenceAt(int(params.Position.Line)+1, sourceColumn(doc.Lines, params.Position))
+	if symbol == nil {
+		return reply(ctx, nil, fmt.Errorf("no symbol at cursor"))
+	}
+
+	if err := checkRenamable(symbol); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	newName := params.NewName
+	if err := validateNewName(newName); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	// Renaming to the same name is a no-op
+	if newName == shortSymbolName(symbol) {
+		return reply(ctx, &protocol.WorkspaceEdit{}, nil)
+	}
+
+	if err := checkRenameConflicts(doc.SymbolTable, symbol, newName); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	edits := []protocol.TextEdit{}
+	for _, ref := range doc.SymbolTable.FindReferences(symbol, true) {
+		edits = append(edits, protocol.TextEdit{
+			Range:   referenceToRange(doc.Lines, ref),
+			NewText: newName,
+		})
+	}
+
+	version := doc.Version
+	result := &protocol.WorkspaceEdit{
+		DocumentChanges: []protocol.TextDocumentEdit{
+			{
+				TextDocument: protocol.OptionalVersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: params.TextDocument.URI},
+					Version:                &version,
+				},
+				Edits: edits,
+			},
+		},
+	}
+
+	// Other workspace files call top-level functions by name
+	if symbol.Kind == SymbolKindFunction && doc.SymbolTable.ScopeOf(symbol) == doc.SymbolTable.GlobalScope {
+		changes, err := s.callerRenameEdits(ctx, doc, symbol.Name, newName)
+		if err != nil {
+			return reply(ctx, nil, err)
+		}
+		result.DocumentChanges = append(result.DocumentChanges, changes...)
+	}
+
+	return reply(ctx, result, nil)
+}
+
+// callerRenameEdits renames the calls to a top-level function of doc made
+// from other workspace files. A file's calls are renamed when they resolve
+// to doc's function the way the call hierarchy resolves them. Open files
+// are edited at their current version; files that are only on disk carry
+// no version.
+func (s *Server) callerRenameEdits(ctx context.Context, doc *Document, name, newName string) ([]protocol.TextDocumentEdit, error) {
+	docs := s.workspace.documents()
+	callees := newCalleeResolver(docs, name)
+	conflicts := newCalleeResolver(docs, newName)
+	changes := []protocol.TextDocumentEdit{}
+	for _, other := range docs {
+		if other.URI == doc.URI || other.AST == nil {
+			continue
+		}
+		if callee := callees.resolve(other); callee == nil || callee.doc.URI != doc.URI {
+			continue
+		}
+
+		var version *int32
+		if s.getWorker(other.URI) != nil {
+			if live := s.liveDocument(ctx, other.URI); live != nil && live.AST != nil {
+				other = live
+			}
+			version = &other.Version
+		}
+
+		// The callers would resolve newName to something else
+		if other.SymbolTable.GlobalScope.LookupLocal(newName) != nil {
+			return nil, fmt.Errorf("'%s' is already declared in %s", newName, filepath.Base(other.URI.Filename()))
+		}
+		if callee := conflicts.resolve(other); callee != nil {
+			return nil, fmt.Errorf("'%s' is already a function in %s", newName, filepath.Base(callee.doc.URI.Filename()))
+		}
+
+		edits := []protocol.TextEdit{}
+		for _, site := range collectCallSites(other.AST) {
+			if site.call.Value == name {
+				edits = append(edits, protocol.TextEdit{
+					Range:   callSiteRange(other, site.call),
+					NewText: newName,
+				})
+			}
+		}
+		if len(edits) == 0 {
+			continue
+		}
+
+		changes = append(changes, protocol.TextDocumentEdit{
+			TextDocument: protocol.OptionalVersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: other.URI},
+				Version:                version,
+			},
+			Edits: edits,
+		})
+	}
+
+	return changes, nil
+}
+
+// checkRenamable rejects symbols whose occurrences are not all tracked
+func checkRenamable(symbol *Symbol) error {
+	// Struct and enum names also appear inside type annotations, which are
+	// not recorded as references, so a rename would leave them dangling
+	switch symbol.Kind {
+	case SymbolKindStruct:
+		return fmt.Errorf("renaming struct types is not supported")
+	case SymbolKindEnum:
+		return fmt.Errorf("renaming enum types is not supported")
+	}
+	return nil
+}
+
+// validateNewName checks that a name is a legal Ahoy identifier
+func validateNewName(name string) error {
+	if name == "" {
+		return fmt.Errorf("new name must not be empty")
+	}
+
+	for i, ch := range name {
+		if !isWordChar(ch) {
+			return fmt.Errorf("'%s' is not a valid identifier", name)
+		}
+		if i == 0 && ch >= '0' && ch <= '9' {
+			return fmt.Errorf("'%s' is not a valid identifier: it starts with a digit", name)
+		}
+	}
+
+	if getKeywordHover(name) != "" {
+		return fmt.Errorf("'%s' is a reserved keyword", name)
+	}
+
+	return nil
+}
+
+// shortSymbolName strips the parent prefix from nested type names such as
+// "point.smoke_particle", which is how the name appears in source
+func shortSymbolName(symbol *Symbol) string {
+	if i := strings.LastIndex(symbol.Name, "."); i >= 0 {
+		return symbol.Name[i+1:]
+	}
+	return symbol.Name
+}
+
+// checkRenameConflicts reports an error when newName is already bound where
+// it would collide with, shadow, or be shadowed by the renamed symbol
+func checkRenameConflicts(st *SymbolTable, symbol *Symbol, newName string) error {
+	scope := st.ScopeOf(symbol)
+	if scope == nil {
+		return fmt.Errorf("cannot determine the scope of '%s'", symbol.Name)
+	}
+
+	fullName := newName
+	if i := strings.LastIndex(symbol.Name, "."); i >= 0 {
+		fullName = symbol.Name[:i+1] + newName
+	}
+
+	// Same scope: a direct collision
+	if existing := scope.LookupLocal(fullName); existing != nil {
+		return fmt.Errorf("'%s' is already declared in this scope at line %d", newName, existing.Line)
+	}
+
+	// Enclosing scopes: the renamed symbol would shadow an existing one
+	for parent := scope.Parent; parent != nil; parent = parent.Parent {
+		if existing := parent.LookupLocal(fullName); existing != nil {
+			return fmt.Errorf("'%s' would shadow the %s declared at line %d", newName, symbolKindName(existing.Kind), existing.Line)
+		}
+	}
+
+	// Nested scopes: an inner declaration would capture the renamed uses
+	if existing := findInNestedScopes(scope, fullName); existing != nil {
+		return fmt.Errorf("'%s' would be shadowed by the %s declared at line %d", newName, symbolKindName(existing.Kind), existing.Line)
+	}
+
+	return nil
+}
+
+func findInNestedScopes(scope *Scope, name string) *Symbol {
+	for _, child := range scope.Children {
+		if sym := child.LookupLocal(name); sym != nil {
+			return sym
+		}
+		if sym := findInNestedScopes(child, name); sym != nil {
+			return sym
+		}
+	}
+	return nil
+}
+
+// symbolKindName returns a human readable name for a symbol kind
+func symbolKindName(kind SymbolKind) string {
+	switch kind {
+	case SymbolKindFunction:
+		return "function"
+	case SymbolKindParameter:
+		return "parameter"
+	case SymbolKindEnum:
+		return "enum"
+	case SymbolKindEnumValue:
+		return "enum value"
+	case SymbolKindStruct:
+		return "struct"
+	case SymbolKindStructField:
+		return "field"
+	case SymbolKindConstant:
+		return "constant"
+	default:
+		return "variable"
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"ahoy"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// The protocol package has the selection range types but no method name
+const methodTextDocumentSelectionRange = "textDocument/selectionRange"
+
+func (s *Server) handleSelectionRange(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.SelectionRangeParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil {
+		return reply(ctx, []protocol.SelectionRange{}, nil)
+	}
+
+	// The result must have one entry per requested position
+	results := make([]protocol.SelectionRange, 0, len(params.Positions))
+	for _, pos := range params.Positions {
+		results = append(results, selectionRangeAt(doc, pos))
+	}
+
+	return reply(ctx, results, nil)
+}
+
+// selectionRangeAt builds the chain of ranges "expand selection" walks
+// through from pos: the word, then each enclosing AST node out to the
+// top-level declaration. The argument list of a call is a step of its own.
+func selectionRangeAt(doc *Document, pos protocol.Position) protocol.SelectionRange {
+	line := int(pos.Line) + 1
+	column := sourceColumn(doc.Lines, pos)
+
+	spans := []nodeSpan{}
+	if word, ok := wordSpan(doc.Lines, line, column); ok {
+		spans = append(spans, word)
+	}
+
+	path := enclosingNodes(doc, line, column)
+	for i := len(path) - 1; i >= 0; i-- {
+		node := path[i]
+		if node.Type == ahoy.NODE_CALL || node.Type == ahoy.NODE_METHOD_CALL {
+			if args, ok := argumentsSpan(doc, node); ok && args.contains(line, column) {
+				spans = append(spans, args)
+			}
+		}
+		spans = append(spans, doc.Spans[node])
+	}
+
+	// Build the linked list from the outside in, dropping ranges that don't
+	// strictly grow the selection
+	var outer *protocol.SelectionRange
+	for i := len(spans) - 1; i >= 0; i-- {
+		if outer != nil {
+			outerSpan := spans[i+1]
+			if spans[i] == outerSpan || !outerSpan.encloses(spans[i]) {
+				spans[i] = outerSpan
+				continue
+			}
+		}
+		outer = &protocol.SelectionRange{Range: spans[i].toRange(doc.Lines), Parent: outer}
+	}
+
+	if outer == nil {
+		return protocol.SelectionRange{Range: protocol.Range{Start: pos, End: pos}}
+	}
+	return *outer
+}
+
+// enclosingNodes returns the AST nodes whose span contains the position,
+// outermost first
+func enclosingNodes(doc *Document, line, column int) []*ahoy.ASTNode {
+	path := []*ahoy.ASTNode{}
+	if doc.AST == nil || doc.Spans == nil {
+		return path
+	}
+
+	children := doc.AST.Children
+	for depth := 0; depth < 1000; depth++ {
+		var next *ahoy.ASTNode
+		for _, child := range children {
+			if span, ok := doc.Spans[child]; ok && span.contains(line, column) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		path = append(path, next)
+		children = next.Children
+		if next.DefaultValue != nil {
+			children = append(append([]*ahoy.ASTNode{}, children...), next.DefaultValue)
+		}
+	}
+
+	return path
+}
+
+// argumentsSpan covers a call's argument list including its pipes
+func argumentsSpan(doc *Document, call *ahoy.ASTNode) (nodeSpan, bool) {
+	var args nodeSpan
+	ok := false
+	for _, child := range call.Children {
+		if span, childOK := doc.Spans[child]; childOK {
+			if ok {
+				args = args.union(span)
+			} else {
+				args, ok = span, true
+			}
+		}
+	}
+	if !ok {
+		return args, false
+	}
+
+	b := &spanBuilder{lines: doc.Lines}
+	if b.charBefore(args.StartLine, args.StartColumn, "|") {
+		args.StartColumn = b.skipBack(args.StartLine, args.StartColumn) - 1
+	}
+	if b.charAfter(args.EndLine, args.EndColumn, "|") {
+		args.EndColumn = b.skipForward(args.EndLine, args.EndColumn) + 1
+	}
+
+	return args, true
+}
+
+// wordSpan covers the identifier at the position, if any
+func wordSpan(lines []string, line, column int) (nodeSpan, bool) {
+	if line <= 0 || line > len(lines) {
+		return nodeSpan{}, false
+	}
+	text := lines[line-1]
+	if column > len(text) {
+		return nodeSpan{}, false
+	}
+
+	start := column
+	for start > 0 && isWordChar(rune(text[start-1])) {
+		start--
+	}
+	end := column
+	for end < len(text) && isWordChar(rune(text[end])) {
+		end++
+	}
+
+	if start == end {
+		return nodeSpan{}, false
+	}
+	return nodeSpan{StartLine: line, StartColumn: start, EndLine: line, EndColumn: end}, true
+}
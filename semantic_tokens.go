@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"ahoy"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Semantic token types
+const (
+	SemanticTokenTypeKeyword = iota
+	SemanticTokenTypeFunction
+	SemanticTokenTypeVariable
+	SemanticTokenTypeParameter
+	SemanticTokenTypeString
+	SemanticTokenTypeNumber
+	SemanticTokenTypeOperator
+	SemanticTokenTypeComment
+	SemanticTokenTypeType
+	SemanticTokenTypeEnum
+	SemanticTokenTypeEnumMember
+	SemanticTokenTypeStruct
+	SemanticTokenTypeProperty
+	SemanticTokenTypeMethod
+)
+
+// Semantic token modifiers
+const (
+	SemanticTokenModifierDeclaration = 1 << iota
+	SemanticTokenModifierDefinition
+	SemanticTokenModifierReadonly
+	SemanticTokenModifierStatic
+	SemanticTokenModifierDefaultLibrary
+)
+
+// semanticTokensOptions is the LSP 3.16 semantic tokens capability. The
+// protocol package's SemanticTokensOptions has no legend, range or full fields.
+type semanticTokensOptions struct {
+	Legend protocol.SemanticTokensLegend `json:"legend"`
+	Range  bool                          `json:"range"`
+	Full   semanticTokensFullOptions     `json:"full"`
+}
+
+type semanticTokensFullOptions struct {
+	Delta bool `json:"delta"`
+}
+
+// semanticTokensResult is the last full result sent for a document, kept so
+// full/delta requests can be answered with edits
+type semanticTokensResult struct {
+	ResultID string
+	Data     []uint32
+}
+
+func (s *Server) handleSemanticTokensFull(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.SemanticTokensParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	builder := NewSemanticTokensBuilder()
+	builder.collect(doc)
+	data := builder.Build()
+
+	result := protocol.SemanticTokens{
+		ResultID: s.storeSemanticTokens(doc.URI, data),
+		Data:     data,
+	}
+
+	return reply(ctx, result, nil)
+}
+
+func (s *Server) handleSemanticTokensRange(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.SemanticTokensRangeParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	builder := NewSemanticTokensBuilder()
+	builder.collect(doc)
+	builder.Restrict(params.Range)
+
+	result := protocol.SemanticTokens{
+		Data: builder.Build(),
+	}
+
+	return reply(ctx, result, nil)
+}
+
+func (s *Server) handleSemanticTokensFullDelta(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.SemanticTokensDeltaParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	builder := NewSemanticTokensBuilder()
+	builder.collect(doc)
+	data := builder.Build()
+
+	s.mu.RLock()
+	previous := s.semanticTokens[doc.URI]
+	s.mu.RUnlock()
+
+	resultID := s.storeSemanticTokens(doc.URI, data)
+
+	// Without the previous result we can only send the full token set
+	if previous == nil || previous.ResultID != params.PreviousResultID {
+		return reply(ctx, protocol.SemanticTokens{ResultID: resultID, Data: data}, nil)
+	}
+
+	result := protocol.SemanticTokensDelta{
+		ResultID: resultID,
+		Edits:    diffSemanticTokens(previous.Data, data),
+	}
+
+	return reply(ctx, result, nil)
+}
+
+// storeSemanticTokens remembers the data sent for a document and returns its result id
+func (s *Server) storeSemanticTokens(docURI uri.URI, data []uint32) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.semanticTokensSeq++
+	resultID := fmt.Sprintf("%d", s.semanticTokensSeq)
+	s.semanticTokens[docURI] = &semanticTokensResult{
+		ResultID: resultID,
+		Data:     data,
+	}
+	return resultID
+}
+
+// diffSemanticTokens expresses the change from previous to current as a
+// single edit covering everything between their common prefix and suffix
+func diffSemanticTokens(previous, current []uint32) []protocol.SemanticTokensEdit {
+	prefix := 0
+	for prefix < len(previous) && prefix < len(current) && previous[prefix] == current[prefix] {
+		prefix++
+	}
+
+	if prefix == len(previous) && prefix == len(current) {
+		return []protocol.SemanticTokensEdit{}
+	}
+
+	suffix := 0
+	for suffix < len(previous)-prefix && suffix < len(current)-prefix &&
+		previous[len(previous)-1-suffix] == current[len(current)-1-suffix] {
+		suffix++
+	}
+
+	return []protocol.SemanticTokensEdit{
+		{
+			Start:       uint32(prefix),
+			DeleteCount: uint32(len(previous) - prefix - suffix),
+			Data:        current[prefix : len(current)-suffix],
+		},
+	}
+}
+
+type SemanticTokensBuilder struct {
+	tokens []SemanticToken
+}
+
+type SemanticToken struct {
+	Line      int // 0-based
+	Column    int // 0-based, in UTF-16 code units once collected
+	Length    int
+	TokenType int
+	Modifiers int
+}
+
+func NewSemanticTokensBuilder() *SemanticTokensBuilder {
+	return &SemanticTokensBuilder{
+		tokens: []SemanticToken{},
+	}
+}
+
+func (b *SemanticTokensBuilder) Add(line, column, length, tokenType, modifiers int) {
+	if length <= 0 {
+		return
+	}
+	b.tokens = append(b.tokens, SemanticToken{
+		Line:      line,
+		Column:    column,
+		Length:    length,
+		TokenType: tokenType,
+		Modifiers: modifiers,
+	})
+}
+
+// Restrict drops every token outside the given range
+func (b *SemanticTokensBuilder) Restrict(rng protocol.Range) {
+	kept := b.tokens[:0]
+	for _, token := range b.tokens {
+		if token.Line < int(rng.Start.Line) || token.Line > int(rng.End.Line) {
+			continue
+		}
+		if token.Line == int(rng.Start.Line) && token.Column+token.Length <= int(rng.Start.Character) {
+			continue
+		}
+		if token.Line == int(rng.End.Line) && token.Column >= int(rng.End.Character) {
+			continue
+		}
+		kept = append(kept, token)
+	}
+	b.tokens = kept
+}
+
+func (b *SemanticTokensBuilder) Build() []uint32 {
+	// The encoding is relative to the previous token, so tokens must be in
+	// document order and must not overlap
+	sort.SliceStable(b.tokens, func(i, j int) bool {
+		if b.tokens[i].Line != b.tokens[j].Line {
+			return b.tokens[i].Line < b.tokens[j].Line
+		}
+		return b.tokens[i].Column < b.tokens[j].Column
+	})
+
+	// Convert to LSP semantic tokens format (delta encoding)
+	data := []uint32{}
+	prevLine := 0
+	prevCol := 0
+	prevEnd := -1
+
+	for _, token := range b.tokens {
+		if token.Line == prevLine && token.Column < prevEnd {
+			continue
+		}
+
+		// Delta line
+		deltaLine := token.Line - prevLine
+
+		// Delta column (0 if different line)
+		deltaCol := token.Column
+		if deltaLine == 0 {
+			deltaCol = token.Column - prevCol
+		}
+
+		data = append(data,
+			uint32(deltaLine),
+			uint32(deltaCol),
+			uint32(token.Length),
+			uint32(token.TokenType),
+			uint32(token.Modifiers),
+		)
+
+		prevLine = token.Line
+		prevCol = token.Column
+		prevEnd = token.Column + token.Length
+	}
+
+	return data
+}
+
+// collect classifies every token of the document. Positions come from the
+// tokenizer; identifiers are classified through the symbol table's
+// reference index so each occurrence resolves in its own scope.
+func (b *SemanticTokensBuilder) collect(doc *Document) {
+	if doc == nil || doc.Lines == nil {
+		return
+	}
+
+	// Comments are not tokenized, so find them in the source
+	commentStart := make(map[int]int)
+	for i, line := range doc.Lines {
+		if col := commentColumn(line); col >= 0 {
+			commentStart[i+1] = col
+			b.Add(i, col, len(line)-col, SemanticTokenTypeComment, 0)
+		}
+	}
+
+	type position struct{ line, column int }
+	symbols := make(map[position]*Symbol)
+	declarations := make(map[position]bool)
+	if doc.SymbolTable != nil {
+		for sym, refs := range doc.SymbolTable.References {
+			for _, ref := range refs {
+				pos := position{ref.Line, ref.Column}
+				symbols[pos] = sym
+				declarations[pos] = ref.IsDeclaration
+			}
+		}
+	}
+
+	fieldDeclarations := collectFieldDeclarations(doc.AST)
+
+	for _, tok := range doc.Tokens {
+		if tok.Value == "" || tok.Line <= 0 || tok.Line > len(doc.Lines) {
+			continue
+		}
+
+		line := doc.Lines[tok.Line-1]
+		column := tokenColumn(tok, doc.Lines)
+		if column < 0 {
+			continue
+		}
+		if start, ok := commentStart[tok.Line]; ok && column >= start {
+			continue
+		}
+
+		// String tokens carry their unquoted value
+		if isStringToken(line, column, tok.Value) {
+			start, end := column-1, column+len(tok.Value)+1
+			if start > 0 && line[start-1] == 'f' {
+				start--
+			}
+			b.Add(tok.Line-1, start, end-start, SemanticTokenTypeString, 0)
+			continue
+		}
+
+		first := tok.Value[0]
+		if first >= '0' && first <= '9' {
+			b.Add(tok.Line-1, column, len(tok.Value), SemanticTokenTypeNumber, 0)
+			continue
+		}
+
+		if !isWordChar(rune(first)) {
+			continue
+		}
+
+		if tokenType, ok := keywordTokenType(tok.Value); ok {
+			b.Add(tok.Line-1, column, len(tok.Value), tokenType, 0)
+			continue
+		}
+
+		pos := position{tok.Line, column}
+		if sym := symbols[pos]; sym != nil {
+			tokenType, modifiers := symbolTokenType(sym)
+			if declarations[pos] {
+				modifiers |= SemanticTokenModifierDeclaration
+			}
+			b.Add(tok.Line-1, column, len(tok.Value), tokenType, modifiers)
+			continue
+		}
+
+		if fieldDeclarations[tok.Line][tok.Value] {
+			b.Add(tok.Line-1, column, len(tok.Value), SemanticTokenTypeProperty, SemanticTokenModifierDeclaration)
+			continue
+		}
+
+		// Unresolved names: classify from the surrounding syntax
+		end := column + len(tok.Value)
+		calls := end < len(line) && line[end] == '|'
+		switch {
+		case column > 0 && line[column-1] == '.' && calls:
+			b.Add(tok.Line-1, column, len(tok.Value), SemanticTokenTypeMethod, SemanticTokenModifierDefaultLibrary)
+		case column > 0 && line[column-1] == '.':
+			b.Add(tok.Line-1, column, len(tok.Value), SemanticTokenTypeProperty, 0)
+		case calls && isBuiltinFunction(tok.Value):
+			b.Add(tok.Line-1, column, len(tok.Value), SemanticTokenTypeFunction, SemanticTokenModifierDefaultLibrary)
+		case doc.SymbolTable != nil && isStructType(doc.SymbolTable, tok.Value):
+			b.Add(tok.Line-1, column, len(tok.Value), SemanticTokenTypeStruct, 0)
+		}
+	}
+
+	// Tokens were placed by byte column, but LSP counts UTF-16 code units
+	for i := range b.tokens {
+		token := &b.tokens[i]
+		if token.Line < len(doc.Lines) {
+			line := doc.Lines[token.Line]
+			start := utf16Column(line, token.Column)
+			token.Length = utf16Column(line, token.Column+token.Length) - start
+			token.Column = start
+		}
+	}
+}
+
+// symbolTokenType maps a symbol kind to its semantic token type and modifiers
+func symbolTokenType(sym *Symbol) (int, int) {
+	switch sym.Kind {
+	case SymbolKindFunction:
+		return SemanticTokenTypeFunction, 0
+	case SymbolKindParameter:
+		return SemanticTokenTypeParameter, 0
+	case SymbolKindEnum:
+		return SemanticTokenTypeEnum, 0
+	case SymbolKindEnumValue:
+		return SemanticTokenTypeEnumMember, SemanticTokenModifierReadonly
+	case SymbolKindStruct:
+		return SemanticTokenTypeStruct, 0
+	case SymbolKindStructField:
+		return SemanticTokenTypeProperty, 0
+	case SymbolKindConstant:
+		return SemanticTokenTypeVariable, SemanticTokenModifierReadonly
+	default:
+		return SemanticTokenTypeVariable, 0
+	}
+}
+
+// keywordTokenType classifies reserved words, word operators and built-in type names
+func keywordTokenType(word string) (int, bool) {
+	switch word {
+	case "plus", "minus", "times", "div", "mod", "lesser", "greater", "is", "not", "and", "or":
+		return SemanticTokenTypeOperator, true
+	case "int", "float", "string", "bool", "dict", "array", "char", "void", "infer", "generic", "vector2", "color":
+		return SemanticTokenTypeType, true
+	case "program", "from":
+		return SemanticTokenTypeKeyword, true
+	}
+
+	if getKeywordHover(word) != "" {
+		return SemanticTokenTypeKeyword, true
+	}
+	return 0, false
+}
+
+// isStructType reports whether name is a declared struct (used in type annotations)
+func isStructType(st *SymbolTable, name string) bool {
+	sym := st.GlobalScope.LookupLocal(name)
+	return sym != nil && sym.Kind == SymbolKindStruct
+}
+
+// collectFieldDeclarations returns, per line, the struct field names declared there
+func collectFieldDeclarations(node *ahoy.ASTNode) map[int]map[string]bool {
+	fields := make(map[int]map[string]bool)
+
+	var walk func(*ahoy.ASTNode)
+	walk = func(n *ahoy.ASTNode) {
+		if n == nil {
+			return
+		}
+
+		if n.Type == ahoy.NODE_STRUCT_DECLARATION || n.Type == ahoy.NODE_TYPE {
+			for _, child := range n.Children {
+				if child != nil && child.Type == ahoy.NODE_IDENTIFIER {
+					if fields[child.Line] == nil {
+						fields[child.Line] = make(map[string]bool)
+					}
+					fields[child.Line][child.Value] = true
+				}
+			}
+		}
+
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+
+	walk(node)
+	return fields
+}
+
+// commentColumn returns the column of the '?' starting a comment on the
+// line, ignoring question marks inside string literals, or -1
+func commentColumn(line string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		if isQuote(ch) {
+			quote = ch
+		} else if ch == '?' {
+			return i
+		}
+	}
+	return -1
+}
+
+func isQuote(ch byte) bool {
+	return ch == '"' || ch == '\''
+}
+
+// GetSemanticTokensLegend returns the legend for semantic tokens
+func GetSemanticTokensLegend() protocol.SemanticTokensLegend {
+	return protocol.SemanticTokensLegend{
+		TokenTypes: []protocol.SemanticTokenTypes{
+			protocol.SemanticTokenKeyword,
+			protocol.SemanticTokenFunction,
+			protocol.SemanticTokenVariable,
+			protocol.SemanticTokenParameter,
+			protocol.SemanticTokenString,
+			protocol.SemanticTokenNumber,
+			protocol.SemanticTokenOperator,
+			protocol.SemanticTokenComment,
+			protocol.SemanticTokenType,
+			protocol.SemanticTokenEnum,
+			protocol.SemanticTokenEnumMember,
+			protocol.SemanticTokenStruct,
+			protocol.SemanticTokenProperty,
+			protocol.SemanticTokenMethod,
+		},
+		TokenModifiers: []protocol.SemanticTokenModifiers{
+			protocol.SemanticTokenModifierDeclaration,
+			protocol.SemanticTokenModifierDefinition,
+			protocol.SemanticTokenModifierReadonly,
+			protocol.SemanticTokenModifierStatic,
+			protocol.SemanticTokenModifierDefaultLibrary,
+		},
+	}
+}
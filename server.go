@@ -4,9 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 	"sync"
-	"time"
 
 	"ahoy"
 
@@ -15,6 +13,8 @@ import (
 	"go.lsp.dev/uri"
 )
 
+// Document is an immutable snapshot of an analyzed document. Snapshots are
+// replaced, never modified, so readers can use them without holding Server.mu.
 type Document struct {
 	URI         uri.URI
 	Content     string
@@ -24,18 +24,56 @@ type Document struct {
 	AST         *ahoy.ASTNode
 	Errors      []ahoy.ParseError
 	SymbolTable *SymbolTable
+	Spans       map[*ahoy.ASTNode]nodeSpan // Source extent of each AST node
 }
 
 type Server struct {
 	conn      jsonrpc2.Conn
-	documents map[uri.URI]*Document
+	documents map[uri.URI]*Document // Latest published snapshot per document
+	workers   map[uri.URI]*documentWorker
 	mu        sync.RWMutex
+
+	// Last semantic tokens sent per document, for full/delta requests
+	semanticTokens    map[uri.URI]*semanticTokensResult
+	semanticTokensSeq uint64
+
+	// Every .ahoy file under the workspace folders, for cross-file features
+	workspace *workspaceIndex
+
+	// Effective options and the raw layers they are computed from, guarded
+	// by mu
+	settings        settings
+	settingsVersion uint64 // Counts the times settings were resolved
+	projectSettings []json.RawMessage
+	initOptions     json.RawMessage
+	clientSettings  json.RawMessage
+
+	// Client capabilities used after initialization, guarded by mu
+	pullConfiguration bool
+	canWatchFiles     bool
+
+	// Serializes the registration of project file watchers, which follow the
+	// workspace folders; watchingProjects is guarded by it
+	watchMu          sync.Mutex
+	watchingProjects bool
+
+	// The client pulls diagnostics, so none are pushed; guarded by mu
+	pullDiagnostics bool
+
+	// Lifecycle state, guarded by mu. exited is set by the exit
+	// notification, which closes the connection.
+	shutdown bool
+	exited   bool
 }
 
 func NewServer(conn jsonrpc2.Conn) *Server {
 	return &Server{
-		conn:      conn,
-		documents: make(map[uri.URI]*Document),
+		conn:           conn,
+		documents:      make(map[uri.URI]*Document),
+		workers:        make(map[uri.URI]*documentWorker),
+		semanticTokens: make(map[uri.URI]*semanticTokensResult),
+		workspace:      newWorkspaceIndex(),
+		settings:       defaultSettings(),
 	}
 }
 
@@ -56,11 +94,11 @@ func (s *Server) Handle(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc
 	case protocol.MethodInitialize:
 		return s.handleInitialize(ctx, reply, req)
 	case protocol.MethodInitialized:
-		return reply(ctx, nil, nil)
+		return s.handleInitialized(ctx, reply, req)
 	case protocol.MethodShutdown:
-		return reply(ctx, nil, nil)
+		return s.handleShutdown(ctx, reply, req)
 	case protocol.MethodExit:
-		return nil
+		return s.handleExit(ctx, reply, req)
 	case protocol.MethodTextDocumentDidOpen:
 		return s.handleDidOpen(ctx, reply, req)
 	case protocol.MethodTextDocumentDidChange:
@@ -73,48 +111,175 @@ func (s *Server) Handle(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc
 		return s.handleCompletion(ctx, reply, req)
 	case protocol.MethodTextDocumentDefinition:
 		return s.handleDefinition(ctx, reply, req)
+	case protocol.MethodTextDocumentTypeDefinition:
+		return s.handleTypeDefinition(ctx, reply, req)
 	case protocol.MethodTextDocumentHover:
 		return s.handleHover(ctx, reply, req)
+	case protocol.MethodTextDocumentSignatureHelp:
+		return s.handleSignatureHelp(ctx, reply, req)
 	case protocol.MethodTextDocumentDocumentSymbol:
 		return s.handleDocumentSymbol(ctx, reply, req)
 	case protocol.MethodTextDocumentCodeAction:
 		return s.handleCodeAction(ctx, reply, req)
+	case protocol.MethodTextDocumentDocumentHighlight:
+		return s.handleDocumentHighlight(ctx, reply, req)
+	case protocol.MethodTextDocumentReferences:
+		return s.handleReferences(ctx, reply, req)
+	case protocol.MethodSemanticTokensFull:
+		return s.handleSemanticTokensFull(ctx, reply, req)
+	case protocol.MethodSemanticTokensRange:
+		return s.handleSemanticTokensRange(ctx, reply, req)
+	case protocol.MethodSemanticTokensFullDelta:
+		return s.handleSemanticTokensFullDelta(ctx, reply, req)
+	case protocol.MethodTextDocumentPrepareRename:
+		return s.handlePrepareRename(ctx, reply, req)
+	case protocol.MethodTextDocumentRename:
+		return s.handleRename(ctx, reply, req)
+	case protocol.MethodTextDocumentFormatting:
+		return s.handleFormatting(ctx, reply, req)
+	case protocol.MethodTextDocumentRangeFormatting:
+		return s.handleRangeFormatting(ctx, reply, req)
+	case protocol.MethodTextDocumentOnTypeFormatting:
+		return s.handleOnTypeFormatting(ctx, reply, req)
+	case protocol.MethodTextDocumentPrepareCallHierarchy:
+		return s.handlePrepareCallHierarchy(ctx, reply, req)
+	case protocol.MethodCallHierarchyIncomingCalls:
+		return s.handleIncomingCalls(ctx, reply, req)
+	case protocol.MethodCallHierarchyOutgoingCalls:
+		return s.handleOutgoingCalls(ctx, reply, req)
+	case methodTextDocumentPrepareTypeHierarchy:
+		return s.handlePrepareTypeHierarchy(ctx, reply, req)
+	case methodTypeHierarchySupertypes:
+		return s.handleTypeHierarchySupertypes(ctx, reply, req)
+	case methodTypeHierarchySubtypes:
+		return s.handleTypeHierarchySubtypes(ctx, reply, req)
+	case methodTextDocumentDiagnostic:
+		return s.handleDocumentDiagnostic(ctx, reply, req)
+	case methodWorkspaceDiagnostic:
+		return s.handleWorkspaceDiagnostic(ctx, reply, req)
+	case protocol.MethodTextDocumentFoldingRange:
+		return s.handleFoldingRange(ctx, reply, req)
+	case methodTextDocumentSelectionRange:
+		return s.handleSelectionRange(ctx, reply, req)
+	case methodTextDocumentInlayHint:
+		return s.handleInlayHint(ctx, reply, req)
+	case protocol.MethodWorkspaceDidChangeConfiguration:
+		return s.handleDidChangeConfiguration(ctx, reply, req)
+	case protocol.MethodWorkspaceDidChangeWatchedFiles:
+		return s.handleDidChangeWatchedFiles(ctx, reply, req)
+	case protocol.MethodWorkspaceSymbol:
+		return s.handleWorkspaceSymbol(ctx, reply, req)
+	case protocol.MethodWorkspaceDidChangeWorkspaceFolders:
+		return s.handleDidChangeWorkspaceFolders(ctx, reply, req)
 	default:
 		return reply(ctx, nil, jsonrpc2.ErrMethodNotFound)
 	}
 }
 
+// serverCapabilities adds the LSP 3.17 capabilities that the protocol
+// package doesn't know about
+type serverCapabilities struct {
+	protocol.ServerCapabilities
+	InlayHintProvider     bool               `json:"inlayHintProvider,omitempty"`
+	TypeHierarchyProvider bool               `json:"typeHierarchyProvider,omitempty"`
+	DiagnosticProvider    *diagnosticOptions `json:"diagnosticProvider,omitempty"`
+}
+
+type initializeResult struct {
+	Capabilities serverCapabilities   `json:"capabilities"`
+	ServerInfo   *protocol.ServerInfo `json:"serverInfo,omitempty"`
+}
+
 func (s *Server) handleInitialize(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
 	var params protocol.InitializeParams
 	if err := json.Unmarshal(req.Params(), &params); err != nil {
 		return reply(ctx, nil, err)
 	}
 
-	result := protocol.InitializeResult{
-		Capabilities: protocol.ServerCapabilities{
-			TextDocumentSync: protocol.TextDocumentSyncOptions{
-				OpenClose: true,
-				Change:    protocol.TextDocumentSyncKindFull,
+	roots := workspaceRoots(params)
+	workspaceCapabilities := params.Capabilities.Workspace
+
+	s.mu.Lock()
+	s.initOptions = rawSettings(params.InitializationOptions)
+	s.projectSettings = loadProjectSettings(roots)
+	s.pullDiagnostics = clientPullsDiagnostics(req.Params())
+	if workspaceCapabilities != nil {
+		s.pullConfiguration = workspaceCapabilities.Configuration
+		s.canWatchFiles = workspaceCapabilities.DidChangeWatchedFiles != nil &&
+			workspaceCapabilities.DidChangeWatchedFiles.DynamicRegistration
+	}
+	s.mu.Unlock()
+
+	// Limits apply before the workspace is indexed
+	s.updateSettings(roots)
+
+	capabilities := protocol.ServerCapabilities{
+		TextDocumentSync: protocol.TextDocumentSyncOptions{
+			OpenClose: true,
+			Change:    protocol.TextDocumentSyncKindIncremental,
+		},
+		CompletionProvider: &protocol.CompletionOptions{
+			TriggerCharacters: []string{".", ":", " "},
+		},
+		SignatureHelpProvider: &protocol.SignatureHelpOptions{
+			TriggerCharacters: []string{"|", ","},
+		},
+		DefinitionProvider:        true,
+		TypeDefinitionProvider:    true,
+		HoverProvider:             true,
+		DocumentSymbolProvider:    true,
+		WorkspaceSymbolProvider:   true,
+		ReferencesProvider:        true,
+		DocumentHighlightProvider: true,
+		RenameProvider: &protocol.RenameOptions{
+			PrepareProvider: true,
+		},
+		DocumentFormattingProvider:      true,
+		DocumentRangeFormattingProvider: true,
+		DocumentOnTypeFormattingProvider: &protocol.DocumentOnTypeFormattingOptions{
+			FirstTriggerCharacter: "\n",
+		},
+		FoldingRangeProvider:   true,
+		SelectionRangeProvider: true,
+		CallHierarchyProvider:  true,
+		SemanticTokensProvider: semanticTokensOptions{
+			Legend: GetSemanticTokensLegend(),
+			Range:  true,
+			Full:   semanticTokensFullOptions{Delta: true},
+		},
+		CodeActionProvider: protocol.CodeActionOptions{
+			CodeActionKinds: []protocol.CodeActionKind{
+				protocol.QuickFix,
+				protocol.Refactor,
 			},
-			CompletionProvider: &protocol.CompletionOptions{
-				TriggerCharacters: []string{".", ":", " "},
+		},
+		Workspace: &protocol.ServerCapabilitiesWorkspace{
+			WorkspaceFolders: &protocol.ServerCapabilitiesWorkspaceFolders{
+				Supported:           true,
+				ChangeNotifications: true,
 			},
-			DefinitionProvider:     true,
-			HoverProvider:          true,
-			DocumentSymbolProvider: true,
-			CodeActionProvider: protocol.CodeActionOptions{
-				CodeActionKinds: []protocol.CodeActionKind{
-					protocol.QuickFix,
-					protocol.Refactor,
-				},
+		},
+	}
+
+	result := initializeResult{
+		Capabilities: serverCapabilities{
+			ServerCapabilities:    capabilities,
+			InlayHintProvider:     true,
+			TypeHierarchyProvider: true,
+			DiagnosticProvider: &diagnosticOptions{
+				Identifier:           "ahoy",
+				WorkspaceDiagnostics: true,
 			},
 		},
 		ServerInfo: &protocol.ServerInfo{
 			Name:    "ahoy-lsp",
-			Version: "0.1.0",
+			Version: version,
 		},
 	}
 
+	// Index the workspace in the background so initialization isn't delayed
+	s.workspace.addRoots(roots)
+
 	return reply(ctx, result, nil)
 }
 
@@ -127,194 +292,89 @@ func (s *Server) handleDidOpen(ctx context.Context, reply jsonrpc2.Replier, req
 	debugLog.Printf("DidOpen: %s (size: %d bytes)", params.TextDocument.URI, len(params.TextDocument.Text))
 
 	// Safety check: prevent opening extremely large files
-	if len(params.TextDocument.Text) > 5000000 {
+	if len(params.TextDocument.Text) > s.getSettings().Limits.MaxDocumentSize {
 		debugLog.Printf("File too large, skipping parsing: %d bytes", len(params.TextDocument.Text))
 		return reply(ctx, nil, fmt.Errorf("file too large"))
 	}
 
-	doc := &Document{
-		URI:     params.TextDocument.URI,
-		Content: params.TextDocument.Text,
-		Lines:   strings.Split(params.TextDocument.Text, "\n"),
-		Version: params.TextDocument.Version,
-	}
-
-	// Parse the document - handle panics gracefully with timeout
-	parseSuccess := false
-	parseDone := make(chan bool, 1)
-	
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				// Parser panicked - create error diagnostic
-				debugLog.Printf("Parser panic: %v", r)
-				doc.Errors = []ahoy.ParseError{
-					{
-						Line:    1,
-						Column:  1,
-						Message: fmt.Sprintf("Parser error: %v", r),
-					},
-				}
-				doc.AST = nil
-				doc.Tokens = nil
-			}
-			parseDone <- true
-		}()
-
-		doc.Tokens = ahoy.Tokenize(doc.Content)
-		debugLog.Printf("Tokenized: %d tokens", len(doc.Tokens))
-		doc.AST, doc.Errors = ahoy.ParseLint(doc.Tokens)
-		debugLog.Printf("Parsed: %d errors", len(doc.Errors))
-		parseSuccess = true
-	}()
-
-	// Wait for parsing with timeout (5 seconds safety)
-	select {
-	case <-parseDone:
-		if !parseSuccess {
-			debugLog.Printf("Parsing failed")
-		} else {
-			debugLog.Printf("Parsing completed successfully")
-		}
-	case <-time.After(5 * time.Second):
-		debugLog.Printf("Parser timeout after 5 seconds!")
-		doc.Errors = []ahoy.ParseError{
-			{
-				Line:    1,
-				Column:  1,
-				Message: "Parser timeout - file may be too complex",
-			},
-		}
-		doc.AST = nil
-		doc.Tokens = nil
-	}
-
-	// Build symbol table - only if AST exists
-	if doc.AST != nil {
-		debugLog.Printf("Building symbol table...")
-		doc.SymbolTable = BuildSymbolTable(doc.AST)
-		debugLog.Printf("Symbol table built")
-	} else {
-		doc.SymbolTable = NewSymbolTable()
+	worker := &documentWorker{
+		uri:     params.TextDocument.URI,
+		content: params.TextDocument.Text,
+		version: params.TextDocument.Version,
 	}
 
 	s.mu.Lock()
-	s.documents[doc.URI] = doc
+	if previous := s.workers[worker.uri]; previous != nil {
+		previous.stop()
+	}
+	s.workers[worker.uri] = worker
+	delete(s.documents, worker.uri)
 	s.mu.Unlock()
+	s.workspace.opened(worker.uri)
 
-	// Send diagnostics
-	s.publishDiagnostics(ctx, doc)
+	// The first analysis runs without a debounce so the document is usable
+	// as soon as possible
+	s.schedule(worker, 0)
 
 	debugLog.Printf("DidOpen complete")
 	return reply(ctx, nil, nil)
 }
 
 func (s *Server) handleDidChange(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
-	var params protocol.DidChangeTextDocumentParams
+	var params didChangeTextDocumentParams
 	if err := json.Unmarshal(req.Params(), &params); err != nil {
 		return reply(ctx, nil, err)
 	}
 
-	debugLog.Printf("DidChange: %s", params.TextDocument.URI)
+	debugLog.Printf("DidChange: %s (version %d, %d changes)", params.TextDocument.URI, params.TextDocument.Version, len(params.ContentChanges))
 
-	s.mu.Lock()
-	doc := s.documents[params.TextDocument.URI]
-	if doc == nil {
-		s.mu.Unlock()
+	worker := s.getWorker(params.TextDocument.URI)
+	if worker == nil {
 		return reply(ctx, nil, fmt.Errorf("document not found"))
 	}
 
-	// Full sync - replace entire content
-	if len(params.ContentChanges) > 0 {
-		debugLog.Printf("Content size: %d bytes", len(params.ContentChanges[0].Text))
+	// Only the worker's own lock is held, so readers of published snapshots
+	// and other documents are never blocked by an edit
+	worker.mu.Lock()
 
-		// Safety check: prevent extremely large files from causing issues
-		if len(params.ContentChanges[0].Text) > 5000000 {
-			debugLog.Printf("File too large after change, skipping reparse: %d bytes", len(params.ContentChanges[0].Text))
-			s.mu.Unlock()
-			return reply(ctx, nil, nil)
-		}
-		// Explicitly clear old data to help GC and prevent memory leaks
-		if doc.SymbolTable != nil {
-			doc.SymbolTable.Clear()
-			doc.SymbolTable = nil
-		}
-		if doc.Tokens != nil {
-			doc.Tokens = nil
-		}
-		if doc.AST != nil {
-			doc.AST = nil
-		}
-		if doc.Errors != nil {
-			doc.Errors = nil
-		}
+	// Versions must strictly increase; applying a stale incremental change
+	// on top of newer content would corrupt the document
+	if params.TextDocument.Version <= worker.version {
+		debugLog.Printf("Out-of-order change for %s: got version %d, document is at version %d, ignoring",
+			params.TextDocument.URI, params.TextDocument.Version, worker.version)
+		worker.mu.Unlock()
+		return reply(ctx, nil, nil)
+	}
+	if params.TextDocument.Version > worker.version+1 {
+		debugLog.Printf("Version gap for %s: expected %d, got %d",
+			params.TextDocument.URI, worker.version+1, params.TextDocument.Version)
+	}
 
-		doc.Content = params.ContentChanges[0].Text
-		doc.Lines = strings.Split(doc.Content, "\n")
-		doc.Version = params.TextDocument.Version
-
-		// Reparse - handle panics gracefully with timeout
-		parseSuccess := false
-		parseDone := make(chan bool, 1)
-		
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					// Parser panicked - create error diagnostic
-					debugLog.Printf("Parser panic on change: %v", r)
-					doc.Errors = []ahoy.ParseError{
-						{
-							Line:    1,
-							Column:  1,
-							Message: fmt.Sprintf("Parser error: %v", r),
-						},
-					}
-					doc.AST = nil
-					doc.Tokens = nil
-				}
-				parseDone <- true
-			}()
-
-			// Tokenize and parse
-			doc.Tokens = ahoy.Tokenize(doc.Content)
-			debugLog.Printf("Tokenized on change: %d tokens", len(doc.Tokens))
-			doc.AST, doc.Errors = ahoy.ParseLint(doc.Tokens)
-			debugLog.Printf("Parsed on change: %d errors", len(doc.Errors))
-			parseSuccess = true
-		}()
-
-		// Wait for parsing with timeout
-		select {
-		case <-parseDone:
-			if !parseSuccess {
-				debugLog.Printf("Parsing failed on change")
-			} else {
-				debugLog.Printf("Parsing completed on change")
-			}
-		case <-time.After(5 * time.Second):
-			debugLog.Printf("Parser timeout on change after 5 seconds!")
-			doc.Errors = []ahoy.ParseError{
-				{
-					Line:    1,
-					Column:  1,
-					Message: "Parser timeout - file may be too complex",
-				},
-			}
-			doc.AST = nil
-			doc.Tokens = nil
-		}
+	content, err := applyContentChanges(worker.content, params.ContentChanges)
+	if err != nil {
+		debugLog.Printf("Failed to apply changes to %s: %v", params.TextDocument.URI, err)
+		worker.mu.Unlock()
+		return reply(ctx, nil, err)
+	}
 
-		// Rebuild symbol table - only if AST exists
-		if doc.AST != nil {
-			doc.SymbolTable = BuildSymbolTable(doc.AST)
-		} else {
-			doc.SymbolTable = NewSymbolTable()
-		}
+	debugLog.Printf("Content size: %d bytes", len(content))
+
+	// Keep the text in sync so later incremental edits still apply
+	worker.content = content
+	worker.version = params.TextDocument.Version
+	worker.mu.Unlock()
+
+	// Safety check: prevent extremely large files from causing issues. The
+	// last analysis no longer matches the text, so it is replaced rather
+	// than left to serve requests and diagnostics.
+	if limit := s.getSettings().Limits.MaxDocumentSize; len(content) > limit {
+		debugLog.Printf("File too large after change, skipping reparse: %d bytes", len(content))
+		worker.stop()
+		s.publishAnalysis(worker, oversizedDocument(worker.uri, content, params.TextDocument.Version, limit))
+		return reply(ctx, nil, nil)
 	}
-	s.mu.Unlock()
 
-	// Send diagnostics
-	s.publishDiagnostics(ctx, doc)
+	s.schedule(worker, analysisDebounce)
 
 	return reply(ctx, nil, nil)
 }
@@ -325,38 +385,72 @@ func (s *Server) handleDidClose(ctx context.Context, reply jsonrpc2.Replier, req
 		return reply(ctx, nil, err)
 	}
 
+	debugLog.Printf("Closing document, cleaning up: %s", params.TextDocument.URI)
+
 	s.mu.Lock()
-	// Clear document data before removing to help GC
-	if doc := s.documents[params.TextDocument.URI]; doc != nil {
-		debugLog.Printf("Closing document, cleaning up: %s", params.TextDocument.URI)
-		if doc.SymbolTable != nil {
-			doc.SymbolTable.Clear()
-			doc.SymbolTable = nil
-		}
-		if doc.Tokens != nil {
-			doc.Tokens = nil
-		}
-		if doc.AST != nil {
-			doc.AST = nil
-		}
-		if doc.Errors != nil {
-			doc.Errors = nil
-		}
-		doc.Content = ""
-		doc.Lines = nil
-	}
+	worker := s.workers[params.TextDocument.URI]
+	delete(s.workers, params.TextDocument.URI)
+	// Snapshots are immutable and may still be in use by a reader, so they
+	// are dropped rather than cleared
 	delete(s.documents, params.TextDocument.URI)
+	delete(s.semanticTokens, params.TextDocument.URI)
 	s.mu.Unlock()
 
+	if worker != nil {
+		worker.stop()
+	}
+	s.workspace.close(params.TextDocument.URI)
+
 	// Send empty diagnostics to clear them in the editor
-	s.conn.Notify(ctx, protocol.MethodTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{
-		URI:         params.TextDocument.URI,
-		Diagnostics: []protocol.Diagnostic{},
-	})
+	if s.pushesDiagnostics() {
+		s.conn.Notify(ctx, protocol.MethodTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []protocol.Diagnostic{},
+		})
+	}
+
+	return reply(ctx, nil, nil)
+}
+
+func (s *Server) handleShutdown(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	s.mu.Lock()
+	s.shutdown = true
+	s.mu.Unlock()
 
 	return reply(ctx, nil, nil)
 }
 
+// handleExit stops every document worker and closes the connection, which
+// ends serveConn. Whether the process exits too is up to the transport.
+func (s *Server) handleExit(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	s.mu.Lock()
+	s.exited = true
+	workers := s.workers
+	s.workers = make(map[uri.URI]*documentWorker)
+	s.mu.Unlock()
+
+	for _, worker := range workers {
+		worker.stop()
+	}
+
+	debugLog.Println("Exit requested, closing the connection")
+	return s.conn.Close()
+}
+
+// exitStatus reports whether the client sent exit and, if so, whether a
+// shutdown request came first
+func (s *Server) exitStatus() (exited, clean bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exited, s.shutdown
+}
+
+func (s *Server) getWorker(docURI uri.URI) *documentWorker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.workers[docURI]
+}
+
 func (s *Server) getDocument(docURI uri.URI) *Document {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
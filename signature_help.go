@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// callContext describes the pipe-delimited call surrounding the cursor
+type callContext struct {
+	Name         string // Function or method name
+	IsMethod     bool   // Called as receiver.method|...|
+	Receiver     string // Identifier before the dot, if any
+	ReceiverType string // Type of a literal receiver ("string", "array", "dict")
+	ArgIndex     int    // Index of the argument under the cursor
+	nesting      int    // Open brackets inside the argument list
+}
+
+func (s *Server) handleSignatureHelp(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.SignatureHelpParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil || doc.Lines == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	line := int(params.Position.Line)
+	if line < 0 || line >= len(doc.Lines) || len(doc.Lines[line]) > 10000 {
+		return reply(ctx, nil, nil)
+	}
+
+	// Argument lists can span lines, so the whole statement around the
+	// cursor is scanned
+	offset := positionToOffset(doc.Content, params.Position)
+	start, end := statementBounds(doc.Content, offset)
+
+	funcSignatures := collectFunctionSignatures(doc.AST)
+	isFunction := func(name string) bool {
+		_, ok := funcSignatures[name]
+		return ok || isBuiltinFunction(name)
+	}
+
+	call := findEnclosingCall(doc.Content[start:end], offset-start, isFunction)
+	if call == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	signatures := []protocol.SignatureInformation{}
+	if call.IsMethod {
+		receiverType := call.ReceiverType
+		if receiverType == "" && call.Receiver != "" && doc.SymbolTable != nil {
+			if sym := doc.SymbolTable.LookupAt(call.Receiver, line+1, sourceColumn(doc.Lines, params.Position)); sym != nil {
+				receiverType = sym.Type
+			}
+		}
+		signatures = builtinMethodSignatures(receiverType, call.Name)
+	} else if sig, ok := funcSignatures[call.Name]; ok {
+		signatures = append(signatures, functionSignatureInformation(sig))
+	}
+
+	if len(signatures) == 0 {
+		return reply(ctx, nil, nil)
+	}
+
+	for i := range signatures {
+		signatures[i].ActiveParameter = uint32(call.ArgIndex)
+	}
+
+	result := protocol.SignatureHelp{
+		Signatures:      signatures,
+		ActiveSignature: 0,
+		ActiveParameter: uint32(call.ArgIndex),
+	}
+
+	return reply(ctx, result, nil)
+}
+
+// maxStatementLines bounds how far statementBounds looks for the start and
+// end of a statement
+const maxStatementLines = 50
+
+// statementBounds returns the byte range of the lines around offset that
+// belong to one statement. A statement goes on to the next line while a
+// pipe or bracket is left open or its line ends in a comma.
+func statementBounds(content string, offset int) (int, int) {
+	// Back up over the lines that could lead into the one at offset
+	start := strings.LastIndexByte(content[:offset], '\n') + 1
+	for i := 0; i < maxStatementLines && start > 0; i++ {
+		previous := strings.LastIndexByte(content[:start-1], '\n') + 1
+		if !mayContinue(content[previous : start-1]) {
+			break
+		}
+		start = previous
+	}
+
+	// Split the lines from there into statements until the one holding
+	// offset ends
+	pipes, depth := 0, 0
+	lineStart := start
+	for i := 0; ; i++ {
+		lineEnd := len(content)
+		if next := strings.IndexByte(content[lineStart:], '\n'); next >= 0 {
+			lineEnd = lineStart + next
+		}
+		line := content[lineStart:lineEnd]
+
+		pipes, depth = countDelimiters(line, pipes, depth)
+		open := pipes%2 == 1 || depth > 0 || strings.HasSuffix(lineCode(line), ",")
+		if lineEnd == len(content) || (!open && lineEnd >= offset) || i >= 2*maxStatementLines {
+			return start, lineEnd
+		}
+
+		if !open {
+			start = lineEnd + 1
+			pipes, depth = 0, 0
+		}
+		lineStart = lineEnd + 1
+	}
+}
+
+// mayContinue reports whether the code on line could go on to the next
+// line: it ends in a comma, a pipe or an open bracket
+func mayContinue(line string) bool {
+	code := lineCode(line)
+	return code != "" && strings.ContainsRune(",|([{", rune(code[len(code)-1]))
+}
+
+// lineCode returns line without its comment and trailing whitespace
+func lineCode(line string) string {
+	if col := commentColumn(line); col >= 0 {
+		line = line[:col]
+	}
+	return strings.TrimRight(line, " \t\r")
+}
+
+// countDelimiters adds the pipes and the bracket depth of line's code to
+// the counts so far
+func countDelimiters(line string, pipes, depth int) (int, int) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch ch {
+		case '"', '\'':
+			quote = ch
+		case '?':
+			return pipes, depth
+		case '|':
+			pipes++
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		}
+	}
+	return pipes, depth
+}
+
+// findEnclosingCall scans text up to cursor (a byte offset) and returns the
+// innermost call whose |argument list| is still open there. Ahoy uses the
+// same pipe character to open and close arguments, so a pipe only opens a
+// call when it follows a method name, a known function, or starts a new
+// call. A function name can also be an argument itself, so inside a call
+// the text after its pipe decides whether the pipe opens or closes.
+func findEnclosingCall(text string, cursor int, isFunction func(string) bool) *callContext {
+	stack := []*callContext{}
+	var quote byte
+
+	for i := 0; i < cursor && i < len(text); i++ {
+		ch := text[i]
+
+		// Skip over string and char literals, which end with their line
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote || ch == '\n' {
+				quote = 0
+			}
+			continue
+		}
+
+		var top *callContext
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		switch ch {
+		case '"', '\'':
+			quote = ch
+		case '?':
+			// Rest of the line is a comment
+			if next := strings.IndexByte(text[i:], '\n'); next >= 0 {
+				i += next
+			} else {
+				i = len(text)
+			}
+		case '(', '[', '{':
+			if top != nil {
+				top.nesting++
+			}
+		case ')', ']', '}':
+			if top != nil && top.nesting > 0 {
+				top.nesting--
+			}
+		case ',':
+			if top != nil && top.nesting == 0 {
+				top.ArgIndex++
+			}
+		case '|':
+			call := callTarget(text[:i])
+			opens := call != nil && (top == nil || call.IsMethod || isFunction(call.Name))
+			if opens && top != nil && !call.IsMethod {
+				opens = opensNestedCall(text, i)
+			}
+
+			if opens {
+				stack = append(stack, call)
+			} else if top != nil {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	if len(stack) == 0 {
+		return nil
+	}
+	return stack[len(stack)-1]
+}
+
+// opensNestedCall decides whether the pipe at i, which follows a function
+// name inside an open call, opens a nested call rather than closing the
+// open one around a function passed as an argument. It opens when what
+// follows can start an argument: a value, another pipe for a call without
+// arguments, or nothing yet because the pipe was just typed.
+func opensNestedCall(text string, i int) bool {
+	rest := text[i+1:]
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		if !strings.HasPrefix(rest, "?") {
+			break
+		}
+		// Skip a comment up to the end of its line
+		next := strings.IndexByte(rest, '\n')
+		if next < 0 {
+			rest = ""
+			break
+		}
+		rest = rest[next:]
+	}
+
+	if rest == "" {
+		return true
+	}
+
+	ch := rest[0]
+	switch {
+	case ch == '|', isQuote(ch), ch == '(', ch == '[', ch == '{', isDigit(ch):
+		return true
+	case isWordChar(rune(ch)):
+		end := 0
+		for end < len(rest) && isWordChar(rune(rest[end])) {
+			end++
+		}
+		// Word operators like plus and keywords like then follow a value
+		word := rest[:end]
+		return word == "true" || word == "false" || !isKeyword(word)
+	}
+	return false
+}
+
+// callTarget extracts the callee name (and receiver for method calls) that
+// immediately precedes a pipe
+func callTarget(text string) *callContext {
+	text = strings.TrimRight(text, " \t")
+
+	end := len(text)
+	start := end
+	for start > 0 && isWordChar(rune(text[start-1])) {
+		start--
+	}
+	if start == end {
+		return nil
+	}
+
+	call := &callContext{Name: text[start:end]}
+
+	// Method call: receiver.method|...|
+	if start > 0 && text[start-1] == '.' {
+		call.IsMethod = true
+		before := text[:start-1]
+		if before == "" {
+			return call
+		}
+
+		switch before[len(before)-1] {
+		case '"', '\'':
+			call.ReceiverType = "string"
+		case ']':
+			call.ReceiverType = "array"
+		case '}':
+			call.ReceiverType = "dict"
+		default:
+			recvStart := len(before)
+			for recvStart > 0 && isWordChar(rune(before[recvStart-1])) {
+				recvStart--
+			}
+			call.Receiver = before[recvStart:]
+		}
+	}
+
+	return call
+}
+
+// functionSignatureInformation builds signature help for a user-defined function
+func functionSignatureInformation(sig *FunctionSignature) protocol.SignatureInformation {
+	paramInfos := []protocol.ParameterInformation{}
+	paramLabels := []string{}
+
+	for _, param := range sig.Parameters {
+		label := param.Name
+		if param.Type != "" {
+			label += ": " + param.Type
+		}
+		if param.HasDefault {
+			label += " = " + param.Default
+		}
+
+		paramLabels = append(paramLabels, label)
+		paramInfos = append(paramInfos, protocol.ParameterInformation{
+			Label: label,
+		})
+	}
+
+	label := sig.Name + "|" + strings.Join(paramLabels, ", ") + "|"
+	if sig.ReturnType != "" && sig.ReturnType != "void" {
+		label += " -> " + sig.ReturnType
+	}
+
+	return protocol.SignatureInformation{
+		Label:         label,
+		Documentation: fmt.Sprintf("Defined at line %d", sig.Line),
+		Parameters:    paramInfos,
+	}
+}
+
+// builtinMethodSignatures returns signatures for a built-in method. When the
+// receiver type is unknown every type that has the method is offered.
+func builtinMethodSignatures(receiverType, name string) []protocol.SignatureInformation {
+	tables := []struct {
+		typeName string
+		methods  []builtinMethod
+	}{
+		{"string", stringMethods},
+		{"array", arrayMethods},
+		{"dict", dictMethods},
+	}
+
+	signatures := []protocol.SignatureInformation{}
+	for _, table := range tables {
+		if receiverType != "" && receiverType != table.typeName {
+			continue
+		}
+
+		for _, method := range table.methods {
+			if method.label != name {
+				continue
+			}
+
+			paramInfos := []protocol.ParameterInformation{}
+			inner := strings.Trim(method.params, "|")
+			if inner != "" {
+				for _, param := range strings.Split(inner, ",") {
+					paramInfos = append(paramInfos, protocol.ParameterInformation{
+						Label: strings.TrimSpace(param),
+					})
+				}
+			}
+
+			signatures = append(signatures, protocol.SignatureInformation{
+				Label:         table.typeName + "." + method.label + method.params,
+				Documentation: method.description,
+				Parameters:    paramInfos,
+			})
+		}
+	}
+
+	return signatures
+}
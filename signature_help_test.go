@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindEnclosingCall(t *testing.T) {
+	isFunction := func(name string) bool {
+		return name == "greet" || name == "apply" || name == "other"
+	}
+
+	tests := []struct {
+		name string
+		text string // The cursor is at ^
+		call string // "" for no call
+		arg  int
+	}{
+		{"first argument", "greet|^", "greet", 0},
+		{"second argument", "greet|a, ^", "greet", 1},
+		{"after the call", "greet|a, b| ^", "", 0},
+		{"comma inside brackets", "greet|[1, 2], ^", "greet", 1},
+		{"pipe inside a string", `greet|"a|b", ^`, "greet", 1},
+		{"pipe in a comment", "x: 1 ? greet|\ngreet|a, ^", "greet", 1},
+		{"nested call", "greet|other|x, ^", "other", 1},
+		{"after a nested call", "greet|a, other|x|, ^", "greet", 2},
+		{"pipe just typed", "greet|a, other|^", "other", 0},
+		{"call without arguments", "greet|other||, ^", "greet", 1},
+		{"function as an argument", "apply|xs, other|^ + 1", "", 0},
+		{"function argument before another call", "apply|xs, other| + apply|^ys|", "apply", 0},
+		{"function argument then a word operator", "apply|xs, other| plus ^", "", 0},
+		{"nested call with a literal", `apply|other|"s", ^|`, "other", 1},
+		{"method call", "apply|xs.push|^", "push", 0},
+		{"across lines", "greet|\n  a,\n  ^", "greet", 1},
+		{"string ends with its line", "greet|\"a\n  b, ^", "greet", 1},
+	}
+
+	for _, tt := range tests {
+		cursor := strings.Index(tt.text, "^")
+		text := tt.text[:cursor] + tt.text[cursor+1:]
+
+		call := findEnclosingCall(text, cursor, isFunction)
+		switch {
+		case tt.call == "" && call != nil:
+			t.Errorf("%s: found call %q, want none", tt.name, call.Name)
+		case tt.call != "" && call == nil:
+			t.Errorf("%s: found no call, want %q", tt.name, tt.call)
+		case call != nil && (call.Name != tt.call || call.ArgIndex != tt.arg):
+			t.Errorf("%s: found %q argument %d, want %q argument %d", tt.name, call.Name, call.ArgIndex, tt.call, tt.arg)
+		}
+	}
+}
+
+func TestOpensNestedCall(t *testing.T) {
+	tests := []struct {
+		text string // The pipe after "other" is examined
+		want bool
+	}{
+		{"f|other|", true},
+		{"f|other|  ", true},
+		{"f|other|x|", true},
+		{"f|other|1|", true},
+		{`f|other|"s"|`, true},
+		{"f|other|[1]|", true},
+		{"f|other||", true},
+		{"f|other|true|", true},
+		{"f|other| ? note\n  x|", true},
+		{"f|other|, 2", false},
+		{"f|other| + 1", false},
+		{"f|other| plus 1", false},
+		{"f|other| then", false},
+		{"f|other|)", false},
+	}
+
+	for _, tt := range tests {
+		i := strings.Index(tt.text, "other|") + len("other")
+		if got := opensNestedCall(tt.text, i); got != tt.want {
+			t.Errorf("opensNestedCall(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestStatementBounds(t *testing.T) {
+	content := "x: 1\ngreet|a,\n  b|\ny: 2"
+
+	tests := []struct {
+		name   string
+		offset int
+		want   string
+	}{
+		{"single line", 2, "x: 1"},
+		{"first line of a call", strings.Index(content, "a,"), "greet|a,\n  b|"},
+		{"continuation line", strings.Index(content, "b|"), "greet|a,\n  b|"},
+		{"last line", len(content), "y: 2"},
+	}
+
+	for _, tt := range tests {
+		start, end := statementBounds(content, tt.offset)
+		if got := content[start:end]; got != tt.want {
+			t.Errorf("%s: statement %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
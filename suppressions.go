@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.lsp.dev/protocol"
+)
+
+const suppressionPrefix = "ahoy-lsp:"
+
+// suppression is an ignore comment such as
+//
+//	? ahoy-lsp: ignore undefined-function
+//	? ahoy-lsp: ignore-file undeclared-identifier, type-mismatch
+//
+// An ignore covers diagnostics starting on its own line or the next one, an
+// ignore-file covers the whole document.
+type suppression struct {
+	line  int // 1-based
+	file  bool
+	codes []suppressedCode
+}
+
+// suppressedCode is one code listed in a suppression and where it is
+type suppressedCode struct {
+	code        string
+	startColumn int
+	endColumn   int
+	used        bool
+}
+
+// parseSuppressions finds the suppression comments in a document
+func parseSuppressions(lines []string) []*suppression {
+	suppressions := []*suppression{}
+
+	for i, text := range lines {
+		column := commentColumn(text)
+		if column < 0 {
+			continue
+		}
+
+		comment := text[column+1:]
+		offset := column + 1 + len(comment) - len(strings.TrimLeft(comment, " \t"))
+		rest, ok := strings.CutPrefix(text[offset:], suppressionPrefix)
+		if !ok {
+			continue
+		}
+		offset += len(suppressionPrefix)
+
+		fields := fieldSpans(rest)
+		if len(fields) < 2 {
+			continue
+		}
+
+		sup := &suppression{line: i + 1}
+		switch rest[fields[0][0]:fields[0][1]] {
+		case "ignore":
+		case "ignore-file":
+			sup.file = true
+		default:
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			sup.codes = append(sup.codes, suppressedCode{
+				code:        rest[field[0]:field[1]],
+				startColumn: offset + field[0],
+				endColumn:   offset + field[1],
+			})
+		}
+		suppressions = append(suppressions, sup)
+	}
+
+	return suppressions
+}
+
+// fieldSpans splits text on spaces and commas, returning the start and end
+// offset of each field
+func fieldSpans(text string) [][2]int {
+	spans := [][2]int{}
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		separator := i == len(text) || text[i] == ' ' || text[i] == '\t' || text[i] == ','
+		if separator && start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		} else if !separator && start < 0 {
+			start = i
+		}
+	}
+	return spans
+}
+
+// covers reports whether the suppression applies to a (1-based) line
+func (s *suppression) covers(line int) bool {
+	return s.file || line == s.line || line == s.line+1
+}
+
+// applySuppressions drops the diagnostics matched by suppression comments
+// and warns about suppressions that match nothing. Diagnostics without a
+// code, like syntax errors, can't be suppressed.
+func applySuppressions(doc *Document, diagnostics []protocol.Diagnostic, lint lintSettings) []protocol.Diagnostic {
+	suppressions := parseSuppressions(doc.Lines)
+	if len(suppressions) == 0 {
+		return diagnostics
+	}
+
+	result := diagnostics[:0]
+	for _, diagnostic := range diagnostics {
+		if !suppress(suppressions, diagnostic) {
+			result = append(result, diagnostic)
+		}
+	}
+
+	severity, on := lint.severity("unused-suppression", protocol.DiagnosticSeverityWarning)
+	if !on {
+		return result
+	}
+
+	for _, sup := range suppressions {
+		for _, code := range sup.codes {
+			if code.used {
+				continue
+			}
+
+			message := fmt.Sprintf("Suppression of '%s' doesn't match any diagnostic", code.code)
+			if !isLintCode(code.code) {
+				message = fmt.Sprintf("Unknown diagnostic code '%s'", code.code)
+			} else if _, enabled := lint.severity(code.code, protocol.DiagnosticSeverityError); !enabled {
+				// A disabled rule reports nothing to suppress
+				continue
+			}
+
+			result = append(result, protocol.Diagnostic{
+				Range:    lspRange(doc.Lines, sup.line, code.startColumn, sup.line, code.endColumn),
+				Severity: severity,
+				Code:     "unused-suppression",
+				Source:   "ahoy",
+				Message:  message,
+			})
+		}
+	}
+
+	return result
+}
+
+// suppress reports whether a suppression matches the diagnostic, marking the
+// matching codes as used
+func suppress(suppressions []*suppression, diagnostic protocol.Diagnostic) bool {
+	code, _ := diagnostic.Code.(string)
+	if code == "" {
+		return false
+	}
+
+	line := int(diagnostic.Range.Start.Line) + 1
+	matched := false
+	for _, sup := range suppressions {
+		if !sup.covers(line) {
+			continue
+		}
+		for i := range sup.codes {
+			if sup.codes[i].code == code {
+				sup.codes[i].used = true
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+// isLintCode reports whether code belongs to a rule
+func isLintCode(code string) bool {
+	for _, rule := range lintRules {
+		if rule.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sort"
 	"strings"
 
 	"ahoy"
@@ -16,6 +17,8 @@ type Symbol struct {
 	EndLine   int
 	EndColumn int
 	Fields    map[string]*StructField // For struct types, stores fields and nested types
+	// ElementType is the type of an array's elements, when known
+	ElementType string
 	// Don't store Definition node or Scope to prevent memory leaks - AST can't be GC'd
 }
 
@@ -39,13 +42,19 @@ const (
 	SymbolKindConstant
 )
 
-// Scope represents a lexical scope
+// Scope represents a lexical scope. Positions use 1-based lines and 0-based
+// columns; the global scope has no range and covers the whole document.
 type Scope struct {
-	Parent    *Scope
-	Symbols   map[string]*Symbol
-	Children  []*Scope
-	StartLine int
-	EndLine   int
+	Parent      *Scope
+	Symbols     map[string]*Symbol
+	Children    []*Scope
+	StartLine   int
+	StartColumn int
+	EndLine     int
+	EndColumn   int // Exclusive
+	// IsFunction marks a function body; assignments never write through it
+	// to a variable of the same name in an enclosing scope
+	IsFunction bool
 }
 
 func NewScope(parent *Scope) *Scope {
@@ -76,10 +85,43 @@ func (s *Scope) LookupLocal(name string) *Symbol {
 	return s.Symbols[name]
 }
 
+// contains reports whether the position falls inside the scope's range
+func (s *Scope) contains(line, column int) bool {
+	if line < s.StartLine || line > s.EndLine {
+		return false
+	}
+	if line == s.StartLine && column < s.StartColumn {
+		return false
+	}
+	if line == s.EndLine && column > s.EndColumn {
+		return false
+	}
+	return true
+}
+
+// Reference is a single occurrence of a symbol's name in the source
+type Reference struct {
+	Line          int // 1-based, like Symbol.Line
+	Column        int // 0-based
+	EndColumn     int // 0-based, exclusive
+	IsDeclaration bool
+	IsWrite       bool // The occurrence assigns a value to the symbol
+}
+
 // SymbolTable manages all symbols in a document
 type SymbolTable struct {
 	GlobalScope  *Scope
 	CurrentScope *Scope
+	// References maps each symbol to every occurrence that resolved to it
+	References map[*Symbol][]Reference
+	// nodeTokens, lines and predeclared are only set while the table is
+	// being built. nodeTokens holds the token computeNodeSpans matched to
+	// each node.
+	nodeTokens map[*ahoy.ASTNode]*tokenSpan
+	lines      []string
+	// predeclared maps top-level declarations to the symbols added for them
+	// before any body is walked
+	predeclared map[*ahoy.ASTNode]*Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -87,6 +129,7 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{
 		GlobalScope:  global,
 		CurrentScope: global,
+		References:   make(map[*Symbol][]Reference),
 	}
 }
 
@@ -95,6 +138,10 @@ func (st *SymbolTable) Clear() {
 	if st.GlobalScope != nil {
 		st.clearScope(st.GlobalScope)
 	}
+	for k := range st.References {
+		delete(st.References, k)
+	}
+	st.References = nil
 	st.GlobalScope = nil
 	st.CurrentScope = nil
 }
@@ -138,6 +185,142 @@ func (st *SymbolTable) Lookup(name string) *Symbol {
 	return st.CurrentScope.Lookup(name)
 }
 
+// ScopeAt returns the innermost scope containing the position
+func (st *SymbolTable) ScopeAt(line, column int) *Scope {
+	scope := st.GlobalScope
+	for depth := 0; scope != nil && depth < 1000; depth++ {
+		var next *Scope
+		for _, child := range scope.Children {
+			if child != nil && child.contains(line, column) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		scope = next
+	}
+	return scope
+}
+
+// LookupAt resolves name as seen from the position, starting in the
+// innermost scope that contains it. Locals declared further down are not
+// visible yet, so the lookup falls through to an outer declaration.
+func (st *SymbolTable) LookupAt(name string, line, column int) *Symbol {
+	for scope := st.ScopeAt(line, column); scope != nil; scope = scope.Parent {
+		if sym, ok := scope.Symbols[name]; ok && scope.declaredBy(sym, line) {
+			return sym
+		}
+	}
+	return nil
+}
+
+// SymbolsAt returns every symbol visible from the position by name. Inner
+// declarations shadow outer ones.
+func (st *SymbolTable) SymbolsAt(line, column int) map[string]*Symbol {
+	visible := make(map[string]*Symbol)
+	for scope := st.ScopeAt(line, column); scope != nil; scope = scope.Parent {
+		for name, sym := range scope.Symbols {
+			if _, shadowed := visible[name]; !shadowed && scope.declaredBy(sym, line) {
+				visible[name] = sym
+			}
+		}
+	}
+	return visible
+}
+
+// declaredBy reports whether sym is already declared on the line. Top-level
+// declarations and functions are visible throughout their scope.
+func (s *Scope) declaredBy(sym *Symbol, line int) bool {
+	return s.Parent == nil || sym.Kind == SymbolKindFunction || sym.Line <= line
+}
+
+// enterNodeScope opens a scope covering the lines of node, from its
+// indentation to the end of its last line
+func (st *SymbolTable) enterNodeScope(node *ahoy.ASTNode) {
+	st.EnterScope()
+
+	span := (&spanBuilder{lines: st.lines}).lineSpan(node.Line, subtreeEndLine(node, 0))
+	st.CurrentScope.StartLine = span.StartLine
+	st.CurrentScope.StartColumn = span.StartColumn
+	st.CurrentScope.EndLine = span.EndLine
+	st.CurrentScope.EndColumn = span.EndColumn
+}
+
+// lookupAssignable finds the variable, parameter or constant an assignment
+// writes to, without looking past the enclosing function body
+func (st *SymbolTable) lookupAssignable(name string) *Symbol {
+	for scope := st.CurrentScope; scope != nil; scope = scope.Parent {
+		if sym, ok := scope.Symbols[name]; ok {
+			switch sym.Kind {
+			case SymbolKindVariable, SymbolKindParameter, SymbolKindConstant:
+				return sym
+			}
+			return nil
+		}
+		if scope.IsFunction {
+			break
+		}
+	}
+	return nil
+}
+
+// addReference records the occurrence of sym at node, at the position of
+// the token the node was matched to
+func (st *SymbolTable) addReference(sym *Symbol, node *ahoy.ASTNode, isDeclaration bool) {
+	st.recordReference(sym, node, isDeclaration, false)
+}
+
+// addWriteReference records an occurrence that assigns to the symbol
+func (st *SymbolTable) addWriteReference(sym *Symbol, node *ahoy.ASTNode, isDeclaration bool) {
+	st.recordReference(sym, node, isDeclaration, true)
+}
+
+func (st *SymbolTable) recordReference(sym *Symbol, node *ahoy.ASTNode, isDeclaration, isWrite bool) {
+	span := st.nodeTokens[node]
+	if sym == nil || span == nil {
+		return
+	}
+
+	// The declaring occurrence gives the symbol its exact position
+	if isDeclaration && sym.EndLine == 0 {
+		sym.Line, sym.Column = span.Line, span.Column
+		sym.EndLine, sym.EndColumn = span.Line, span.EndColumn
+	}
+
+	st.References[sym] = append(st.References[sym], Reference{
+		Line:          span.Line,
+		Column:        span.Column,
+		EndColumn:     span.EndColumn,
+		IsDeclaration: isDeclaration,
+		IsWrite:       isWrite,
+	})
+}
+
+// ScopeOf returns the scope that declares the given symbol
+func (st *SymbolTable) ScopeOf(sym *Symbol) *Scope {
+	return findDeclaringScope(st.GlobalScope, sym)
+}
+
+func findDeclaringScope(scope *Scope, sym *Symbol) *Scope {
+	if scope == nil || sym == nil {
+		return nil
+	}
+
+	if scope.Symbols[sym.Name] == sym {
+		return scope
+	}
+
+	for _, child := range scope.Children {
+		if found := findDeclaringScope(child, sym); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
 func (st *SymbolTable) FindSymbolAtPosition(line, column int) *Symbol {
 	return st.findSymbolInScope(st.GlobalScope, line, column)
 }
@@ -149,7 +332,7 @@ func (st *SymbolTable) findSymbolInScope(scope *Scope, line, column int) *Symbol
 
 	// Check symbols in current scope
 	for _, sym := range scope.Symbols {
-		if sym.Line == line && sym.Column <= column && column < sym.Column+len(sym.Name) {
+		if sym.Line == line && sym.Column <= column && column < symbolEndColumn(sym) {
 			return sym
 		}
 	}
@@ -166,17 +349,147 @@ func (st *SymbolTable) findSymbolInScope(scope *Scope, line, column int) *Symbol
 	return nil
 }
 
-// BuildSymbolTable walks the AST and builds the symbol table
-func BuildSymbolTable(ast *ahoy.ASTNode) *SymbolTable {
+// symbolEndColumn is the exclusive end of a symbol's name on its line
+func symbolEndColumn(sym *Symbol) int {
+	if sym.EndLine != 0 {
+		return sym.EndColumn
+	}
+	return sym.Column + len(sym.Name)
+}
+
+// BuildSymbolTable walks the AST and builds the symbol table. The tokens
+// computeNodeSpans matched to the nodes give the exact reference positions.
+func BuildSymbolTable(ast *ahoy.ASTNode, nodeTokens map[*ahoy.ASTNode]*tokenSpan, lines []string) *SymbolTable {
 	if ast == nil {
 		return NewSymbolTable()
 	}
 
 	st := NewSymbolTable()
+	st.nodeTokens = nodeTokens
+	st.lines = lines
+	st.predeclare(ast)
 	st.walkNode(ast, 0)
+	st.nodeTokens = nil
+	st.lines = nil
+	st.predeclared = nil
 	return st
 }
 
+// predeclare adds the top-level functions, structs, enums, constants and
+// globals to the global scope before any body is walked, so a use above
+// the declaration (like main calling a helper defined below it) resolves.
+// walkNode picks the symbols up again through declare. Functions come
+// first, so another declaration with a function's name never replaces it.
+func (st *SymbolTable) predeclare(program *ahoy.ASTNode) {
+	st.predeclared = make(map[*ahoy.ASTNode]*Symbol)
+	if program.Type != ahoy.NODE_PROGRAM {
+		return
+	}
+
+	for _, node := range program.Children {
+		if node != nil && node.Type == ahoy.NODE_FUNCTION {
+			st.predeclareSymbol(node, &Symbol{
+				Name: node.Value,
+				Kind: SymbolKindFunction,
+				Type: node.DataType,
+				Line: node.Line,
+			})
+		}
+	}
+
+	for _, node := range program.Children {
+		if node == nil {
+			continue
+		}
+
+		switch node.Type {
+		case ahoy.NODE_STRUCT_DECLARATION:
+			st.predeclareSymbol(node, &Symbol{
+				Name:   node.Value,
+				Kind:   SymbolKindStruct,
+				Type:   "struct",
+				Line:   node.Line,
+				Fields: make(map[string]*StructField),
+			})
+
+		case ahoy.NODE_ENUM_DECLARATION:
+			st.predeclareSymbol(node, &Symbol{
+				Name: node.Value,
+				Kind: SymbolKindEnum,
+				Type: "enum",
+				Line: node.Line,
+			})
+			for _, child := range node.Children {
+				if child != nil && child.Type == ahoy.NODE_IDENTIFIER {
+					st.predeclareSymbol(child, &Symbol{
+						Name: child.Value,
+						Kind: SymbolKindEnumValue,
+						Type: node.Value,
+						Line: child.Line,
+					})
+				}
+			}
+
+		case ahoy.NODE_CONSTANT_DECLARATION:
+			st.predeclareSymbol(node, &Symbol{
+				Name: node.Value,
+				Kind: SymbolKindConstant,
+				Type: node.DataType,
+				Line: node.Line,
+			})
+
+		case ahoy.NODE_VARIABLE_DECLARATION, ahoy.NODE_ASSIGNMENT:
+			// Later top-level assignments write to the first one
+			if st.GlobalScope.LookupLocal(node.Value) == nil {
+				st.predeclareSymbol(node, &Symbol{
+					Name: node.Value,
+					Kind: SymbolKindVariable,
+					Type: node.DataType,
+					Line: node.Line,
+				})
+			}
+		}
+	}
+}
+
+func (st *SymbolTable) predeclareSymbol(node *ahoy.ASTNode, sym *Symbol) {
+	if shadowsFunction(st.GlobalScope, sym) {
+		return
+	}
+	st.GlobalScope.AddSymbol(sym)
+	st.predeclared[node] = sym
+}
+
+// declare adds sym to the current scope, or returns the symbol predeclare
+// already added for node with any type the walk inferred filled in. A
+// declaration with the name of a function in the same scope is kept out of
+// the scope, so the function stays resolvable; checkFunctionNameCollisions
+// reports it.
+func (st *SymbolTable) declare(node *ahoy.ASTNode, sym *Symbol) *Symbol {
+	existing := st.predeclared[node]
+	if existing == nil {
+		if !shadowsFunction(st.CurrentScope, sym) {
+			st.AddSymbol(sym)
+		}
+		return sym
+	}
+
+	if existing.Type == "" {
+		existing.Type = sym.Type
+	}
+	if existing.ElementType == "" {
+		existing.ElementType = sym.ElementType
+	}
+	return existing
+}
+
+// shadowsFunction reports whether sym is not a function but scope already
+// declares a function by its name
+func shadowsFunction(scope *Scope, sym *Symbol) bool {
+	existing := scope.LookupLocal(sym.Name)
+	return existing != nil && existing.Kind == SymbolKindFunction && sym.Kind != SymbolKindFunction
+}
+
 func (st *SymbolTable) walkNode(node *ahoy.ASTNode, depth int) {
 	if node == nil {
 		return
@@ -203,40 +516,37 @@ func (st *SymbolTable) walkNode(node *ahoy.ASTNode, depth int) {
 	case ahoy.NODE_FUNCTION:
 		// Add function to symbol table
 		funcName := node.Value
-		symbol := &Symbol{
+		symbol := st.declare(node, &Symbol{
 			Name:   funcName,
 			Kind:   SymbolKindFunction,
 			Type:   node.DataType,
 			Line:   node.Line,
 			Column: 0,
-		}
-		st.AddSymbol(symbol)
+		})
+		st.addReference(symbol, node, true)
 
 		// Enter function scope
-		st.EnterScope()
-		st.CurrentScope.StartLine = node.Line
+		st.enterNodeScope(node)
+		st.CurrentScope.IsFunction = true
 
 		// Add parameters
 		if len(node.Children) > 0 {
 			params := node.Children[0]
 			if params != nil {
-				for i := 0; i < len(params.Children); i += 2 {
-					if i < len(params.Children) {
-						paramName := params.Children[i].Value
-						paramType := ""
-						if i+1 < len(params.Children) {
-							paramType = params.Children[i+1].Value
-						}
+				for _, param := range params.Children {
+					if param == nil || param.Type != ahoy.NODE_IDENTIFIER {
+						continue
+					}
 
-						paramSymbol := &Symbol{
-							Name:   paramName,
-							Kind:   SymbolKindParameter,
-							Type:   paramType,
-							Line:   params.Children[i].Line,
-							Column: 0,
-						}
-						st.AddSymbol(paramSymbol)
+					paramSymbol := &Symbol{
+						Name:   param.Value,
+						Kind:   SymbolKindParameter,
+						Type:   param.DataType,
+						Line:   param.Line,
+						Column: 0,
 					}
+					st.AddSymbol(paramSymbol)
+					st.addReference(paramSymbol, param, true)
 				}
 			}
 		}
@@ -256,15 +566,36 @@ func (st *SymbolTable) walkNode(node *ahoy.ASTNode, depth int) {
 		if varType == "" && len(node.Children) > 0 {
 			varType = st.inferType(node.Children[0])
 		}
+		elementType := ""
+		if len(node.Children) > 0 {
+			elementType = st.inferElementType(node.Children[0])
+		}
 
-		symbol := &Symbol{
-			Name:   varName,
-			Kind:   SymbolKindVariable,
-			Type:   varType,
-			Line:   node.Line,
-			Column: 0,
+		// Assigning to an existing binding writes to it rather than
+		// declaring a new one
+		var existing *Symbol
+		if st.predeclared[node] == nil {
+			existing = st.lookupAssignable(varName)
+		}
+		if existing != nil {
+			if existing.Type == "" {
+				existing.Type = varType
+			}
+			if existing.ElementType == "" {
+				existing.ElementType = elementType
+			}
+			st.addWriteReference(existing, node, false)
+		} else {
+			symbol := st.declare(node, &Symbol{
+				Name:        varName,
+				Kind:        SymbolKindVariable,
+				Type:        varType,
+				Line:        node.Line,
+				Column:      0,
+				ElementType: elementType,
+			})
+			st.addWriteReference(symbol, node, true)
 		}
-		st.AddSymbol(symbol)
 
 		// Walk the value expression
 		if len(node.Children) > 0 {
@@ -273,39 +604,40 @@ func (st *SymbolTable) walkNode(node *ahoy.ASTNode, depth int) {
 
 	case ahoy.NODE_ENUM_DECLARATION:
 		enumName := node.Value
-		symbol := &Symbol{
+		symbol := st.declare(node, &Symbol{
 			Name:   enumName,
 			Kind:   SymbolKindEnum,
 			Type:   "enum",
 			Line:   node.Line,
 			Column: 0,
-		}
-		st.AddSymbol(symbol)
+		})
+		st.addReference(symbol, node, true)
 
 		// Add enum values
 		for _, child := range node.Children {
 			if child.Type == ahoy.NODE_IDENTIFIER {
-				valueSymbol := &Symbol{
+				valueSymbol := st.declare(child, &Symbol{
 					Name:   child.Value,
 					Kind:   SymbolKindEnumValue,
 					Type:   enumName,
 					Line:   child.Line,
 					Column: 0,
-				}
-				st.AddSymbol(valueSymbol)
+				})
+				st.addReference(valueSymbol, child, true)
 			}
 		}
 
 	case ahoy.NODE_STRUCT_DECLARATION:
 		structName := node.Value
-		symbol := &Symbol{
+		symbol := st.declare(node, &Symbol{
 			Name:   structName,
 			Kind:   SymbolKindStruct,
 			Type:   "struct",
 			Line:   node.Line,
 			Column: 0,
 			Fields: make(map[string]*StructField),
-		}
+		})
+		st.addReference(symbol, node, true)
 
 		// Parse struct fields
 		for _, child := range node.Children {
@@ -349,11 +681,10 @@ func (st *SymbolTable) walkNode(node *ahoy.ASTNode, depth int) {
 					Fields: nestedField.Fields,
 				}
 				st.AddSymbol(nestedSymbol)
+				st.addReference(nestedSymbol, child, true)
 			}
 		}
 
-		st.AddSymbol(symbol)
-
 	case ahoy.NODE_CONSTANT_DECLARATION:
 		constName := node.Value
 		constType := node.DataType
@@ -362,40 +693,62 @@ func (st *SymbolTable) walkNode(node *ahoy.ASTNode, depth int) {
 			constType = st.inferType(node.Children[0])
 		}
 
-		symbol := &Symbol{
-			Name:   constName,
-			Kind:   SymbolKindConstant,
-			Type:   constType,
-			Line:   node.Line,
-			Column: 0,
+		elementType := ""
+		if len(node.Children) > 0 {
+			elementType = st.inferElementType(node.Children[0])
+		}
+		symbol := st.declare(node, &Symbol{
+			Name:        constName,
+			Kind:        SymbolKindConstant,
+			Type:        constType,
+			Line:        node.Line,
+			Column:      0,
+			ElementType: elementType,
+		})
+		st.addWriteReference(symbol, node, true)
+
+		// Walk the value expression
+		if len(node.Children) > 0 {
+			st.walkNode(node.Children[0], depth+1)
 		}
-		st.AddSymbol(symbol)
 
 	case ahoy.NODE_IF_STATEMENT, ahoy.NODE_WHILE_LOOP, ahoy.NODE_FOR_LOOP,
 		ahoy.NODE_FOR_RANGE_LOOP, ahoy.NODE_FOR_COUNT_LOOP,
 		ahoy.NODE_FOR_IN_ARRAY_LOOP, ahoy.NODE_FOR_IN_DICT_LOOP:
 		// Enter new scope for block
-		st.EnterScope()
-		st.CurrentScope.StartLine = node.Line
+		st.enterNodeScope(node)
 
 		// For loops with variables
 		if node.Type == ahoy.NODE_FOR_IN_ARRAY_LOOP && len(node.Children) > 0 {
 			// Add loop variable
 			loopVar := node.Children[0]
 			if loopVar.Type == ahoy.NODE_IDENTIFIER {
+				// The loop variable takes the array's element type
+				loopType := ""
+				if len(node.Children) > 1 {
+					loopType = st.inferElementType(node.Children[1])
+				}
+				if loopType == "" {
+					loopType = "any"
+				}
+
 				symbol := &Symbol{
 					Name:   loopVar.Value,
 					Kind:   SymbolKindVariable,
-					Type:   "any", // Could be inferred from array type
+					Type:   loopType,
 					Line:   loopVar.Line,
 					Column: 0,
 				}
 				st.AddSymbol(symbol)
+				st.addWriteReference(symbol, loopVar, true)
 			}
 		}
 
-		// Walk children
-		for _, child := range node.Children {
+		// Walk children (the loop variable was already declared above)
+		for i, child := range node.Children {
+			if i == 0 && node.Type == ahoy.NODE_FOR_IN_ARRAY_LOOP && child.Type == ahoy.NODE_IDENTIFIER {
+				continue
+			}
 			st.walkNode(child, depth+1)
 		}
 
@@ -406,6 +759,19 @@ func (st *SymbolTable) walkNode(node *ahoy.ASTNode, depth int) {
 			st.walkNode(child, depth+1)
 		}
 
+	case ahoy.NODE_IDENTIFIER:
+		st.addReference(st.Lookup(node.Value), node, false)
+		for _, child := range node.Children {
+			st.walkNode(child, depth+1)
+		}
+
+	case ahoy.NODE_CALL:
+		// The callee name comes before its |arguments|
+		st.addReference(st.Lookup(node.Value), node, false)
+		for _, child := range node.Children {
+			st.walkNode(child, depth+1)
+		}
+
 	default:
 		// Walk all children for other node types
 		for _, child := range node.Children {
@@ -452,6 +818,27 @@ func (st *SymbolTable) inferType(node *ahoy.ASTNode) string {
 	return ""
 }
 
+// inferElementType returns the element type of an array expression, or ""
+// if it isn't known
+func (st *SymbolTable) inferElementType(node *ahoy.ASTNode) string {
+	if node == nil {
+		return ""
+	}
+
+	switch node.Type {
+	case ahoy.NODE_ARRAY_LITERAL:
+		if len(node.Children) > 0 {
+			return st.inferType(node.Children[0])
+		}
+	case ahoy.NODE_IDENTIFIER:
+		if sym := st.Lookup(node.Value); sym != nil {
+			return sym.ElementType
+		}
+	}
+
+	return ""
+}
+
 func (st *SymbolTable) GetStructFields(typeName string) map[string]*StructField {
 	// Look up the struct type
 	sym := st.Lookup(typeName)
@@ -528,50 +915,49 @@ func (st *SymbolTable) collectSymbols(scope *Scope, symbols *[]*Symbol) {
 	}
 }
 
-// FindReferences finds all references to a symbol
-func (st *SymbolTable) FindReferences(symbolName string, ast *ahoy.ASTNode) []Position {
-	positions := []Position{}
-	st.findReferencesInNode(ast, symbolName, &positions, 0)
-	return positions
-}
-
-type Position struct {
-	Line   int
-	Column int
-}
-
-func (st *SymbolTable) findReferencesInNode(node *ahoy.ASTNode, name string, positions *[]Position, depth int) {
-	if node == nil {
-		return
+// FindReferences returns every recorded occurrence of a symbol in source
+// order, optionally including its declaration
+func (st *SymbolTable) FindReferences(sym *Symbol, includeDeclaration bool) []Reference {
+	refs := []Reference{}
+	if sym == nil {
+		return refs
 	}
 
-	// Prevent unbounded recursion and memory issues
-	if depth > 500 {
-		return
-	}
-
-	// Limit number of results to prevent memory exhaustion
-	if len(*positions) > 100 {
-		return
+	for _, ref := range st.References[sym] {
+		if ref.IsDeclaration && !includeDeclaration {
+			continue
+		}
+		refs = append(refs, ref)
 	}
 
-	if node.Type == ahoy.NODE_IDENTIFIER && node.Value == name {
-		*positions = append(*positions, Position{
-			Line:   node.Line,
-			Column: 0,
-		})
-		// Early exit if we have enough references
-		if len(*positions) > 100 {
-			return
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Line != refs[j].Line {
+			return refs[i].Line < refs[j].Line
 		}
-	}
+		return refs[i].Column < refs[j].Column
+	})
 
-	// Limit child iteration
-	if len(node.Children) > 1000 {
-		return
-	}
+	return refs
+}
 
-	for _, child := range node.Children {
-		st.findReferencesInNode(child, name, positions, depth+1)
+// ReferenceAt returns the symbol whose occurrence covers the given position
+// (1-based line, 0-based column) along with that occurrence. A cursor just
+// after a name still finds it, unless another name starts right there, as
+// in a+b with the cursor before b.
+func (st *SymbolTable) ReferenceAt(line, column int) (*Symbol, *Reference) {
+	var endSym *Symbol
+	var endRef *Reference
+	for sym, refs := range st.References {
+		for i := range refs {
+			ref := &refs[i]
+			if ref.Line != line || column < ref.Column || column > ref.EndColumn {
+				continue
+			}
+			if column < ref.EndColumn {
+				return sym, ref
+			}
+			endSym, endRef = sym, ref
+		}
 	}
+	return endSym, endRef
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+
+	"ahoy"
+)
+
+func TestReferenceAt(t *testing.T) {
+	a := &Symbol{Name: "a"}
+	b := &Symbol{Name: "b"}
+	x := &Symbol{Name: "x"}
+	y := &Symbol{Name: "y"}
+
+	st := NewSymbolTable()
+	// Line 1 is "a+b"; line 2 has two names that touch, like a synthetic
+	// "xy" split by the tokenizer
+	st.References[a] = []Reference{{Line: 1, Column: 0, EndColumn: 1}}
+	st.References[b] = []Reference{{Line: 1, Column: 2, EndColumn: 3}}
+	st.References[x] = []Reference{{Line: 2, Column: 0, EndColumn: 1}}
+	st.References[y] = []Reference{{Line: 2, Column: 1, EndColumn: 2}}
+
+	tests := []struct {
+		name   string
+		line   int
+		column int
+		want   *Symbol
+	}{
+		{"start of a name", 1, 0, a},
+		{"just after a name", 1, 1, a},
+		{"start of the next name", 1, 2, b},
+		{"end of the line", 1, 3, b},
+		{"past every name", 1, 4, nil},
+		{"name starting at the cursor wins", 2, 1, y},
+		{"after the second name", 2, 2, y},
+		{"other line", 3, 0, nil},
+	}
+
+	for _, tt := range tests {
+		// Map iteration order varies, so repeat to catch order dependence
+		for i := 0; i < 20; i++ {
+			sym, ref := st.ReferenceAt(tt.line, tt.column)
+			if sym != tt.want {
+				t.Fatalf("%s: ReferenceAt(%d, %d) = %v, want %v", tt.name, tt.line, tt.column, sym, tt.want)
+			}
+			if sym != nil && (ref == nil || ref.Line != tt.line) {
+				t.Fatalf("%s: ReferenceAt(%d, %d) returned reference %+v", tt.name, tt.line, tt.column, ref)
+			}
+		}
+	}
+}
+
+func TestBuildSymbolTableBinaryOperands(t *testing.T) {
+	// a: 1
+	// b: a + a
+	lines := []string{"a: 1", "b: a + a"}
+	tokens := []ahoy.Token{
+		{Value: "a", Line: 1, Column: 1},
+		{Value: ":", Line: 1, Column: 2},
+		{Value: "1", Line: 1, Column: 4},
+		{Value: "b", Line: 2, Column: 1},
+		{Value: ":", Line: 2, Column: 2},
+		{Value: "a", Line: 2, Column: 4},
+		{Value: "+", Line: 2, Column: 6},
+		{Value: "a", Line: 2, Column: 8},
+	}
+	left := &ahoy.ASTNode{Type: ahoy.NODE_IDENTIFIER, Value: "a", Line: 2}
+	right := &ahoy.ASTNode{Type: ahoy.NODE_IDENTIFIER, Value: "a", Line: 2}
+	ast := &ahoy.ASTNode{Type: ahoy.NODE_PROGRAM, Children: []*ahoy.ASTNode{
+		{Type: ahoy.NODE_ASSIGNMENT, Value: "a", Line: 1, Children: []*ahoy.ASTNode{
+			{Type: ahoy.NODE_NUMBER, Value: "1", Line: 1},
+		}},
+		{Type: ahoy.NODE_ASSIGNMENT, Value: "b", Line: 2, Children: []*ahoy.ASTNode{
+			{Type: ahoy.NODE_BINARY_OP, Value: "+", Line: 2, Children: []*ahoy.ASTNode{left, right}},
+		}},
+	}}
+
+	_, nodeTokens := computeNodeSpans(ast, tokens, lines)
+	st := BuildSymbolTable(ast, nodeTokens, lines)
+
+	if got := nodeTokens[left].Column; got != 3 {
+		t.Errorf("left operand matched column %d, want 3", got)
+	}
+	if got := nodeTokens[right].Column; got != 7 {
+		t.Errorf("right operand matched column %d, want 7", got)
+	}
+
+	sym := st.GlobalScope.LookupLocal("a")
+	if sym == nil {
+		t.Fatal("a is not declared")
+	}
+	want := []Reference{
+		{Line: 1, Column: 0, EndColumn: 1, IsDeclaration: true, IsWrite: true},
+		{Line: 2, Column: 3, EndColumn: 4},
+		{Line: 2, Column: 7, EndColumn: 8},
+	}
+	got := st.FindReferences(sym, true)
+	if len(got) != len(want) {
+		t.Fatalf("references = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("reference %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPredeclaredFunctionKeepsItsName(t *testing.T) {
+	// helper: 1
+	// func helper do
+	// end
+	lines := []string{"helper: 1", "func helper do", "end"}
+	tokens := []ahoy.Token{
+		{Value: "helper", Line: 1, Column: 1},
+		{Value: "1", Line: 1, Column: 9},
+		{Value: "func", Line: 2, Column: 1},
+		{Value: "helper", Line: 2, Column: 6},
+	}
+	ast := &ahoy.ASTNode{Type: ahoy.NODE_PROGRAM, Children: []*ahoy.ASTNode{
+		{Type: ahoy.NODE_ASSIGNMENT, Value: "helper", Line: 1, Children: []*ahoy.ASTNode{
+			{Type: ahoy.NODE_NUMBER, Value: "1", Line: 1},
+		}},
+		{Type: ahoy.NODE_FUNCTION, Value: "helper", Line: 2, Children: []*ahoy.ASTNode{
+			{Type: ahoy.NODE_BLOCK, Line: 2},
+			{Type: ahoy.NODE_BLOCK, Line: 2},
+		}},
+	}}
+
+	_, nodeTokens := computeNodeSpans(ast, tokens, lines)
+	st := BuildSymbolTable(ast, nodeTokens, lines)
+
+	sym := st.GlobalScope.LookupLocal("helper")
+	if sym == nil || sym.Kind != SymbolKindFunction {
+		t.Fatalf("helper resolves to %+v, want the function", sym)
+	}
+	if refs := st.FindReferences(sym, true); len(refs) != 1 || refs[0].Line != 2 {
+		t.Errorf("function references = %+v, want its declaration on line 2", refs)
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"strings"
+
+	"ahoy"
 
 	"go.lsp.dev/jsonrpc2"
 	"go.lsp.dev/protocol"
@@ -15,80 +18,244 @@ func (s *Server) handleDocumentSymbol(ctx context.Context, reply jsonrpc2.Replie
 	}
 
 	doc := s.getDocument(params.TextDocument.URI)
-	if doc == nil || doc.SymbolTable == nil {
-		return reply(ctx, nil, nil)
+	if doc == nil || doc.AST == nil {
+		return reply(ctx, []protocol.DocumentSymbol{}, nil)
 	}
 
-	// Build document symbols from symbol table
+	// Build the outline tree from the AST so declarations keep their nesting
+	builder := newOutlineBuilder(doc, s.getSettings().Limits.MaxOutlineSymbols)
+	symbols := builder.collect(doc.AST, map[string]bool{}, 0)
+
+	return reply(ctx, symbols, nil)
+}
+
+// outlineBuilder turns AST declarations into a DocumentSymbol tree
+type outlineBuilder struct {
+	doc    *Document
+	tokens *tokenIndex
+	count  int
+	max    int // Prevents memory exhaustion on huge documents
+}
+
+// defaultMaxOutlineSymbols is the default cap on outline entries
+const defaultMaxOutlineSymbols = 1000
+
+func newOutlineBuilder(doc *Document, max int) *outlineBuilder {
+	return &outlineBuilder{
+		doc:    doc,
+		tokens: newTokenIndex(doc.Tokens, doc.Lines),
+		max:    max,
+	}
+}
+
+// collect returns the outline entries declared under node. seen holds the
+// variable names already listed in the enclosing container, so repeated
+// assignments only appear once.
+func (b *outlineBuilder) collect(node *ahoy.ASTNode, seen map[string]bool, depth int) []protocol.DocumentSymbol {
 	symbols := []protocol.DocumentSymbol{}
+	if node == nil || depth > 1000 {
+		return symbols
+	}
+
+	for _, child := range node.Children {
+		if child == nil || b.count >= b.max {
+			continue
+		}
+
+		switch child.Type {
+		case ahoy.NODE_FUNCTION:
+			symbols = append(symbols, b.function(child, depth))
+
+		case ahoy.NODE_STRUCT_DECLARATION:
+			symbols = append(symbols, b.structure(child, child.Value, child.Value))
 
-	// Get all symbols and organize them hierarchically
-	allSymbols := doc.SymbolTable.GetAllSymbols()
+		case ahoy.NODE_ENUM_DECLARATION:
+			symbols = append(symbols, b.enum(child))
 
-	for _, sym := range allSymbols {
-		// Only include top-level symbols (functions, enums, structs, constants)
-		if shouldIncludeInOutline(sym) {
-			docSymbol := symbolToDocumentSymbol(sym)
-			symbols = append(symbols, docSymbol)
+		case ahoy.NODE_CONSTANT_DECLARATION:
+			symbols = append(symbols, b.leaf(child, child.Value, SymbolKindConstant, declaredType(child)))
+
+		case ahoy.NODE_VARIABLE_DECLARATION, ahoy.NODE_ASSIGNMENT:
+			if !seen[child.Value] {
+				seen[child.Value] = true
+				symbols = append(symbols, b.leaf(child, child.Value, SymbolKindVariable, b.variableType(child, depth)))
+			}
+
+		default:
+			// Declarations inside if/loop/switch blocks belong to the
+			// enclosing container
+			symbols = append(symbols, b.collect(child, seen, depth+1)...)
 		}
 	}
 
-	return reply(ctx, symbols, nil)
+	return symbols
 }
 
-func shouldIncludeInOutline(sym *Symbol) bool {
-	switch sym.Kind {
-	case SymbolKindFunction, SymbolKindEnum, SymbolKindStruct, SymbolKindConstant, SymbolKindVariable:
-		return true
-	default:
-		return false
-	}
-}
-
-func symbolToDocumentSymbol(sym *Symbol) protocol.DocumentSymbol {
-	docSymbol := protocol.DocumentSymbol{
-		Name: sym.Name,
-		Kind: symbolKindToProtocol(sym.Kind),
-		Range: protocol.Range{
-			Start: protocol.Position{
-				Line:      uint32(sym.Line - 1),
-				Character: uint32(sym.Column),
-			},
-			End: protocol.Position{
-				Line:      uint32(sym.Line - 1),
-				Character: uint32(sym.Column + len(sym.Name)),
-			},
-		},
-		SelectionRange: protocol.Range{
-			Start: protocol.Position{
-				Line:      uint32(sym.Line - 1),
-				Character: uint32(sym.Column),
-			},
-			End: protocol.Position{
-				Line:      uint32(sym.Line - 1),
-				Character: uint32(sym.Column + len(sym.Name)),
-			},
-		},
-	}
-
-	// Add type detail
-	if sym.Type != "" {
-		docSymbol.Detail = sym.Type
-	}
-
-	// Note: Children handling removed to prevent circular references and memory leaks
-	// Symbols are now shown flat without hierarchical nesting
-
-	return docSymbol
-}
-
-func shouldIncludeAsChild(sym *Symbol) bool {
-	switch sym.Kind {
-	case SymbolKindParameter, SymbolKindEnumValue, SymbolKindStructField:
-		return true
-	default:
-		return false
+// function lists a function with its parameters and locals as children
+func (b *outlineBuilder) function(node *ahoy.ASTNode, depth int) protocol.DocumentSymbol {
+	symbol := b.newSymbol(node, node.Value, SymbolKindFunction, node.DataType)
+
+	seen := map[string]bool{}
+	if len(node.Children) > 0 && node.Children[0] != nil {
+		for _, param := range node.Children[0].Children {
+			if param == nil || param.Type != ahoy.NODE_IDENTIFIER {
+				continue
+			}
+			seen[param.Value] = true
+			symbol.Children = append(symbol.Children, b.leaf(param, param.Value, SymbolKindParameter, param.DataType))
+		}
+	}
+
+	if len(node.Children) > 1 {
+		symbol.Children = append(symbol.Children, b.collect(node.Children[1], seen, depth+1)...)
+	}
+
+	return symbol
+}
+
+// structure lists a struct (or nested type) with its fields and nested types
+func (b *outlineBuilder) structure(node *ahoy.ASTNode, name, detail string) protocol.DocumentSymbol {
+	symbol := b.newSymbol(node, name, SymbolKindStruct, detail)
+
+	for _, child := range node.Children {
+		if child == nil {
+			continue
+		}
+
+		switch child.Type {
+		case ahoy.NODE_IDENTIFIER:
+			symbol.Children = append(symbol.Children, b.leaf(child, child.Value, SymbolKindStructField, child.DataType))
+		case ahoy.NODE_TYPE:
+			symbol.Children = append(symbol.Children, b.structure(child, child.Value, detail+"."+child.Value))
+		}
+	}
+
+	return symbol
+}
+
+// enum lists an enum with its members
+func (b *outlineBuilder) enum(node *ahoy.ASTNode) protocol.DocumentSymbol {
+	symbol := b.newSymbol(node, node.Value, SymbolKindEnum, "enum")
+
+	for _, child := range node.Children {
+		if child != nil && child.Type == ahoy.NODE_IDENTIFIER {
+			symbol.Children = append(symbol.Children, b.leaf(child, child.Value, SymbolKindEnumValue, ""))
+		}
 	}
+
+	return symbol
+}
+
+func (b *outlineBuilder) leaf(node *ahoy.ASTNode, name string, kind SymbolKind, detail string) protocol.DocumentSymbol {
+	return b.newSymbol(node, name, kind, detail)
+}
+
+// newSymbol creates an outline entry whose Range spans the whole
+// declaration and whose SelectionRange covers just the name
+func (b *outlineBuilder) newSymbol(node *ahoy.ASTNode, name string, kind SymbolKind, detail string) protocol.DocumentSymbol {
+	b.count++
+
+	selection := b.nameRange(node.Line, name)
+	rng := b.declarationRange(node)
+
+	// The selection must be contained in the full range
+	if rng.End.Line == selection.End.Line && rng.End.Character < selection.End.Character {
+		rng.End.Character = selection.End.Character
+	}
+	if rng.Start.Line == selection.Start.Line && rng.Start.Character > selection.Start.Character {
+		rng.Start.Character = selection.Start.Character
+	}
+
+	return protocol.DocumentSymbol{
+		Name:           name,
+		Detail:         detail,
+		Kind:           symbolKindToProtocol(kind),
+		Range:          rng,
+		SelectionRange: selection,
+	}
+}
+
+// nameRange locates name on the given (1-based) line using the tokenizer
+func (b *outlineBuilder) nameRange(line int, name string) protocol.Range {
+	column := 0
+	if span := b.tokens.claim(line, name); span != nil {
+		column = span.Column
+	} else if line > 0 && line <= len(b.doc.Lines) {
+		if i := strings.Index(b.doc.Lines[line-1], name); i >= 0 {
+			column = i
+		}
+	}
+
+	return lspRange(b.doc.Lines, line, column, line, column+len(name))
+}
+
+// declarationRange spans from the first character of the declaration line
+// to the end of the last line of its body
+func (b *outlineBuilder) declarationRange(node *ahoy.ASTNode) protocol.Range {
+	startLine := node.Line
+	endLine := subtreeEndLine(node, 0)
+
+	startChar := 0
+	endChar := 0
+	if startLine > 0 && startLine <= len(b.doc.Lines) {
+		text := b.doc.Lines[startLine-1]
+		startChar = len(text) - len(strings.TrimLeft(text, " \t"))
+	}
+	if endLine > 0 && endLine <= len(b.doc.Lines) {
+		endChar = len(strings.TrimRight(b.doc.Lines[endLine-1], " \t\r"))
+	}
+
+	return lspRange(b.doc.Lines, startLine, startChar, endLine, endChar)
+}
+
+// variableType uses the symbol table's inferred type for globals and falls
+// back to the declaration itself for locals
+func (b *outlineBuilder) variableType(node *ahoy.ASTNode, depth int) string {
+	if depth == 0 && b.doc.SymbolTable != nil && b.doc.SymbolTable.GlobalScope != nil {
+		if sym := b.doc.SymbolTable.GlobalScope.LookupLocal(node.Value); sym != nil && sym.Type != "" {
+			return sym.Type
+		}
+	}
+	return declaredType(node)
+}
+
+// declaredType returns the annotated type of a declaration or, failing
+// that, the type inferred from its value
+func declaredType(node *ahoy.ASTNode) string {
+	if node.DataType != "" {
+		return node.DataType
+	}
+	if len(node.Children) > 0 {
+		if t := inferExpressionType(node.Children[0]); t != "unknown" {
+			return t
+		}
+	}
+	return ""
+}
+
+// subtreeEndLine returns the last line covered by node or any of its descendants
+func subtreeEndLine(node *ahoy.ASTNode, depth int) int {
+	if node == nil {
+		return 0
+	}
+
+	end := node.Line
+	if depth > 1000 {
+		return end
+	}
+
+	for _, child := range node.Children {
+		if childEnd := subtreeEndLine(child, depth+1); childEnd > end {
+			end = childEnd
+		}
+	}
+
+	return end
+}
+
+// symbolRange is the range of a symbol's name where it is declared
+func symbolRange(lines []string, sym *Symbol) protocol.Range {
+	return lspRange(lines, sym.Line, sym.Column, sym.Line, symbolEndColumn(sym))
 }
 
 func symbolKindToProtocol(kind SymbolKind) protocol.SymbolKind {
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.lsp.dev/protocol"
+)
+
+// contentChangeEvent is protocol.TextDocumentContentChangeEvent with an
+// optional range. The protocol package uses a value Range, which can't tell
+// a full-document replacement from an insertion at 0:0.
+type contentChangeEvent struct {
+	Range       *protocol.Range `json:"range,omitempty"`
+	RangeLength uint32          `json:"rangeLength,omitempty"`
+	Text        string          `json:"text"`
+}
+
+type didChangeTextDocumentParams struct {
+	TextDocument   protocol.VersionedTextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []contentChangeEvent                     `json:"contentChanges"`
+}
+
+// applyContentChanges applies each change to content in order. Changes
+// without a range replace the whole document.
+func applyContentChanges(content string, changes []contentChangeEvent) (string, error) {
+	for i, change := range changes {
+		if change.Range == nil {
+			content = change.Text
+			continue
+		}
+
+		start := positionToOffset(content, change.Range.Start)
+		end := positionToOffset(content, change.Range.End)
+		if start > end {
+			return "", fmt.Errorf("change %d has an inverted range %d:%d-%d:%d", i,
+				change.Range.Start.Line, change.Range.Start.Character,
+				change.Range.End.Line, change.Range.End.Character)
+		}
+
+		content = content[:start] + change.Text + content[end:]
+	}
+
+	return content, nil
+}
+
+// positionToOffset converts an LSP position (line, UTF-16 code unit) to a
+// byte offset in content. Positions past the end of a line or of the
+// document are clamped, as the specification requires.
+func positionToOffset(content string, pos protocol.Position) int {
+	offset := 0
+	for line := uint32(0); line < pos.Line; line++ {
+		next := strings.IndexByte(content[offset:], '\n')
+		if next < 0 {
+			return len(content)
+		}
+		offset += next + 1
+	}
+
+	lineEnd := strings.IndexByte(content[offset:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(content)
+	} else {
+		lineEnd += offset
+	}
+
+	units := uint32(0)
+	for offset < lineEnd && units < pos.Character {
+		r, size := utf8.DecodeRuneInString(content[offset:lineEnd])
+		units += uint32(utf16Len(r))
+		offset += size
+	}
+
+	return offset
+}
+
+// utf16Len returns the number of UTF-16 code units needed to encode r
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// utf16Length returns the length of s in UTF-16 code units
+func utf16Length(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16Len(r)
+	}
+	return n
+}
+
+// utf16Column converts a 0-based byte column on line to the UTF-16 code
+// units LSP positions count. Columns past the end of the line keep their
+// overshoot.
+func utf16Column(line string, column int) int {
+	if column <= 0 {
+		return column
+	}
+	if column > len(line) {
+		return utf16Length(line) + column - len(line)
+	}
+	return utf16Length(line[:column])
+}
+
+// byteColumn converts a UTF-16 character offset on line to a byte column.
+// Offsets past the end of the line keep their overshoot, so bounds checks
+// against the line length still see them.
+func byteColumn(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += utf16Len(r)
+	}
+	return len(line) + character - units
+}
+
+// lspPosition converts a 1-based line and 0-based byte column in lines to
+// an LSP position. Every range sent to the client goes through here.
+func lspPosition(lines []string, line, column int) protocol.Position {
+	if line > 0 && line <= len(lines) {
+		column = utf16Column(lines[line-1], column)
+	}
+	return protocol.Position{Line: uint32(line - 1), Character: uint32(column)}
+}
+
+// lspRange is lspPosition for both ends of a range
+func lspRange(lines []string, startLine, startColumn, endLine, endColumn int) protocol.Range {
+	return protocol.Range{
+		Start: lspPosition(lines, startLine, startColumn),
+		End:   lspPosition(lines, endLine, endColumn),
+	}
+}
+
+// sourceColumn converts the character of an LSP position to a 0-based byte
+// column on its line of lines
+func sourceColumn(lines []string, pos protocol.Position) int {
+	if int(pos.Line) >= len(lines) {
+		return int(pos.Character)
+	}
+	return byteColumn(lines[pos.Line], int(pos.Character))
+}
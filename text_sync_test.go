@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+
+	"go.lsp.dev/protocol"
+)
+
+func TestPositionToOffset(t *testing.T) {
+	// "é" is 2 bytes and 1 UTF-16 unit, "😀" 4 bytes and 2 units
+	content := "abc\né1\n😀x\r\n"
+
+	tests := []struct {
+		name      string
+		line      uint32
+		character uint32
+		want      int
+	}{
+		{"start", 0, 0, 0},
+		{"inside ASCII", 0, 2, 2},
+		{"end of line", 0, 3, 3},
+		{"past end of line", 0, 10, 3},
+		{"after two-byte rune", 1, 1, 6},
+		{"after surrogate pair", 2, 2, 12},
+		{"inside surrogate pair", 2, 1, 12},
+		{"after pair and one more", 2, 3, 13},
+		{"carriage return counts", 2, 4, 14},
+		{"empty last line", 3, 0, 15},
+		{"past the last line", 7, 2, 15},
+	}
+
+	for _, tt := range tests {
+		got := positionToOffset(content, protocol.Position{Line: tt.line, Character: tt.character})
+		if got != tt.want {
+			t.Errorf("%s: positionToOffset(%d:%d) = %d, want %d", tt.name, tt.line, tt.character, got, tt.want)
+		}
+	}
+}
+
+func TestApplyContentChanges(t *testing.T) {
+	change := func(startLine, startChar, endLine, endChar uint32, text string) contentChangeEvent {
+		return contentChangeEvent{
+			Range: &protocol.Range{
+				Start: protocol.Position{Line: startLine, Character: startChar},
+				End:   protocol.Position{Line: endLine, Character: endChar},
+			},
+			Text: text,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		changes []contentChangeEvent
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "full replacement",
+			content: "old",
+			changes: []contentChangeEvent{{Text: "new"}},
+			want:    "new",
+		},
+		{
+			name:    "insert at the start is not a replacement",
+			content: "x: 1",
+			changes: []contentChangeEvent{change(0, 0, 0, 0, "? c\n")},
+			want:    "? c\nx: 1",
+		},
+		{
+			name:    "replace across lines",
+			content: "a: 1\nb: 2\nc: 3",
+			changes: []contentChangeEvent{change(0, 3, 2, 1, "9\nd")},
+			want:    "a: 9\nd: 3",
+		},
+		{
+			name:    "changes apply in order",
+			content: "abc",
+			changes: []contentChangeEvent{change(0, 3, 0, 3, "d"), change(0, 0, 0, 1, "")},
+			want:    "bcd",
+		},
+		{
+			name:    "UTF-16 columns after a surrogate pair",
+			content: `s: "😀"`,
+			changes: []contentChangeEvent{change(0, 6, 0, 6, "!")},
+			want:    `s: "😀!"`,
+		},
+		{
+			name:    "delete a surrogate pair",
+			content: `s: "😀é"`,
+			changes: []contentChangeEvent{change(0, 4, 0, 6, "")},
+			want:    `s: "é"`,
+		},
+		{
+			name:    "range past the end is clamped",
+			content: "x",
+			changes: []contentChangeEvent{change(0, 5, 3, 0, "y")},
+			want:    "xy",
+		},
+		{
+			name:    "inverted range",
+			content: "abc",
+			changes: []contentChangeEvent{change(0, 2, 0, 1, "")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := applyContentChanges(tt.content, tt.changes)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestColumnConversions(t *testing.T) {
+	line := "é😀x"
+
+	tests := []struct {
+		name   string
+		column int // Byte column
+		utf16  int
+	}{
+		{"start", 0, 0},
+		{"after two-byte rune", 2, 1},
+		{"after surrogate pair", 6, 3},
+		{"end of line", 7, 4},
+		{"past the end", 9, 6},
+	}
+
+	for _, tt := range tests {
+		if got := utf16Column(line, tt.column); got != tt.utf16 {
+			t.Errorf("%s: utf16Column(%d) = %d, want %d", tt.name, tt.column, got, tt.utf16)
+		}
+		if got := byteColumn(line, tt.utf16); got != tt.column {
+			t.Errorf("%s: byteColumn(%d) = %d, want %d", tt.name, tt.utf16, got, tt.column)
+		}
+	}
+
+	lines := []string{"x", line}
+	pos := lspPosition(lines, 2, 6)
+	if pos != (protocol.Position{Line: 1, Character: 3}) {
+		t.Errorf("lspPosition = %+v, want 1:3", pos)
+	}
+	if got := sourceColumn(lines, pos); got != 6 {
+		t.Errorf("sourceColumn(%+v) = %d, want 6", pos, got)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+
+	"ahoy"
+)
+
+// tokenSpan is the exact source location of a single token
+type tokenSpan struct {
+	Value     string
+	Line      int // 1-based, matches ASTNode.Line
+	Column    int // 0-based
+	EndColumn int // 0-based, exclusive
+	claimed   bool
+	literal   bool // The contents of a string or char literal
+}
+
+// tokenIndex groups token spans by line so AST nodes (which only carry a
+// line number) can be mapped back to exact columns. Comments are not
+// indexed, and literal contents are kept apart from names so a name never
+// claims the text of a string.
+type tokenIndex struct {
+	byLine map[int][]*tokenSpan
+}
+
+func newTokenIndex(tokens []ahoy.Token, lines []string) *tokenIndex {
+	idx := &tokenIndex{
+		byLine: make(map[int][]*tokenSpan),
+	}
+
+	for _, tok := range tokens {
+		if tok.Value == "" || tok.Line <= 0 {
+			continue
+		}
+
+		column := tokenColumn(tok, lines)
+		if column < 0 {
+			continue
+		}
+
+		line := lines[tok.Line-1]
+		if start := commentColumn(line); start >= 0 && column >= start {
+			continue
+		}
+
+		idx.byLine[tok.Line] = append(idx.byLine[tok.Line], &tokenSpan{
+			Value:     tok.Value,
+			Line:      tok.Line,
+			Column:    column,
+			EndColumn: column + len(tok.Value),
+			literal:   isStringToken(line, column, tok.Value),
+		})
+	}
+
+	return idx
+}
+
+// tokenColumn returns the 0-based column of a token. Tokenizer columns are
+// 1-based like ParseError, but string tokens carry their unquoted value, so
+// the position is checked against the source line before it is trusted.
+func tokenColumn(tok ahoy.Token, lines []string) int {
+	if tok.Line > len(lines) {
+		return -1
+	}
+	line := lines[tok.Line-1]
+
+	for _, col := range []int{tok.Column - 1, tok.Column} {
+		if col >= 0 && col+len(tok.Value) <= len(line) && line[col:col+len(tok.Value)] == tok.Value {
+			return col
+		}
+	}
+
+	// Fall back to the first occurrence at or after the reported column
+	start := tok.Column - 1
+	if start < 0 || start > len(line) {
+		start = 0
+	}
+	if i := strings.Index(line[start:], tok.Value); i >= 0 {
+		return start + i
+	}
+	return strings.Index(line, tok.Value)
+}
+
+// isStringToken reports whether the token at column is the contents of a
+// quoted literal. String tokens carry their unquoted value, so the quotes
+// are found around it in the source.
+func isStringToken(line string, column int, value string) bool {
+	if column <= 0 || column > len(line) || !isQuote(line[column-1]) {
+		return false
+	}
+	end := column + len(value)
+	return end < len(line) && line[end] == line[column-1]
+}
+
+// claim returns the first unclaimed token on the given line whose value is
+// name and marks it as used
+func (idx *tokenIndex) claim(line int, name string) *tokenSpan {
+	return idx.claimToken(line, name, false, 0)
+}
+
+// claimLiteral is claim for the contents of a string or char literal
+func (idx *tokenIndex) claimLiteral(line int, value string) *tokenSpan {
+	return idx.claimToken(line, value, true, 0)
+}
+
+// claimToken returns the first unclaimed matching token on the line that
+// starts at or after from and marks it as used. Callers pass the end of the
+// source that precedes the node, so a repeated name resolves to the
+// occurrence at the node's own position. If there is none after from, for
+// a tree that isn't in source order, the first unclaimed one is taken.
+func (idx *tokenIndex) claimToken(line int, value string, literal bool, from int) *tokenSpan {
+	if idx == nil || value == "" {
+		return nil
+	}
+
+	var fallback *tokenSpan
+	for _, span := range idx.byLine[line] {
+		if span.claimed || span.literal != literal || span.Value != value {
+			continue
+		}
+		if span.Column >= from {
+			span.claimed = true
+			return span
+		}
+		if fallback == nil {
+			fallback = span
+		}
+	}
+
+	if fallback != nil {
+		fallback.claimed = true
+	}
+	return fallback
+}
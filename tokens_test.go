@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"ahoy"
+)
+
+func TestClaimToken(t *testing.T) {
+	lines := []string{
+		`x: a + a`,
+		`say|"a"| a`,
+		`a ? a`,
+	}
+	tokens := []ahoy.Token{
+		{Value: "x", Line: 1, Column: 1},
+		{Value: ":", Line: 1, Column: 2},
+		{Value: "a", Line: 1, Column: 4},
+		{Value: "+", Line: 1, Column: 6},
+		{Value: "a", Line: 1, Column: 8},
+		{Value: "say", Line: 2, Column: 1},
+		{Value: "|", Line: 2, Column: 4},
+		{Value: "a", Line: 2, Column: 6},
+		{Value: "|", Line: 2, Column: 8},
+		{Value: "a", Line: 2, Column: 10},
+		{Value: "a", Line: 3, Column: 1},
+		{Value: "a", Line: 3, Column: 5},
+	}
+	idx := newTokenIndex(tokens, lines)
+
+	// The steps share the index, so each one sees the claims made before it
+	steps := []struct {
+		name    string
+		line    int
+		value   string
+		literal bool
+		from    int
+		want    int // Column of the claimed token, or -1 for none
+	}{
+		{"occurrence after from", 1, "a", false, 5, 7},
+		{"falls back to an earlier one", 1, "a", false, 5, 3},
+		{"every occurrence claimed", 1, "a", false, 0, -1},
+		{"name skips literal contents", 2, "a", false, 0, 9},
+		{"literal contents", 2, "a", true, 0, 5},
+		{"no more literals", 2, "a", true, 0, -1},
+		{"comments are not indexed", 3, "a", false, 1, 0},
+		{"comment occurrence never claimed", 3, "a", false, 1, -1},
+		{"empty value", 1, "", false, 0, -1},
+	}
+
+	for _, step := range steps {
+		span := idx.claimToken(step.line, step.value, step.literal, step.from)
+		got := -1
+		if span != nil {
+			got = span.Column
+		}
+		if got != step.want {
+			t.Errorf("%s: claimed column %d, want %d", step.name, got, step.want)
+		}
+	}
+}
+
+func TestTokenColumn(t *testing.T) {
+	lines := []string{`name: "hello"`, `a: 1 + a`}
+
+	tests := []struct {
+		name string
+		tok  ahoy.Token
+		want int
+	}{
+		{"1-based column", ahoy.Token{Value: "name", Line: 1, Column: 1}, 0},
+		{"unquoted string value", ahoy.Token{Value: "hello", Line: 1, Column: 7}, 7},
+		{"later occurrence", ahoy.Token{Value: "a", Line: 2, Column: 8}, 7},
+		{"line past the end", ahoy.Token{Value: "a", Line: 3, Column: 1}, -1},
+		{"not on the line", ahoy.Token{Value: "zzz", Line: 2, Column: 1}, -1},
+	}
+
+	for _, tt := range tests {
+		if got := tokenColumn(tt.tok, lines); got != tt.want {
+			t.Errorf("%s: tokenColumn = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
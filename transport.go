@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// errExitWithoutShutdown is returned by serveConn when the client sent exit
+// without a shutdown request first, which the specification answers with
+// exit code 1
+var errExitWithoutShutdown = errors.New("exit notification received without shutdown")
+
+// serveConn runs a language server over one client connection until it
+// closes or the client sends exit. Every connection gets its own Server, so
+// clients share nothing but the process.
+func serveConn(ctx context.Context, rwc io.ReadWriteCloser) error {
+	stream := jsonrpc2.NewStream(rwc)
+	conn := jsonrpc2.NewConn(stream)
+
+	// Create server
+	server := NewServer(conn)
+	debugLog.Println("Server created successfully")
+
+	// Start JSON-RPC handler
+	handler := jsonrpc2.ReplyHandler(server.Handle)
+	conn.Go(ctx, handler)
+	debugLog.Println("Handler started, waiting for requests")
+
+	// Wait for the connection to close, or for the process to stop
+	select {
+	case <-conn.Done():
+	case <-ctx.Done():
+		conn.Close()
+		<-conn.Done()
+	}
+
+	debugLog.Println("Connection closed")
+	setMemoryLimit(server, 0)
+
+	// Closing the connection on exit makes reads fail; that isn't an error
+	if exited, clean := server.exitStatus(); exited {
+		if !clean {
+			return errExitWithoutShutdown
+		}
+		return nil
+	}
+	return conn.Err()
+}
+
+// listenAndServe accepts clients on a TCP address or Unix socket path and
+// serves each one on its own goroutine. With single, the first client is
+// served and no more are accepted.
+func listenAndServe(ctx context.Context, network, address string, single bool) error {
+	if network == "unix" {
+		// A socket left behind by a previous run would make Listen fail
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	debugLog.Printf("Listening on %s %s", network, listener.Addr())
+
+	// Closing the listener ends the accept loop; Unix listeners also remove
+	// their socket file
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		debugLog.Printf("Client connected from %s", netConn.RemoteAddr())
+
+		if single {
+			listener.Close()
+			return ignoreDisconnect(serveConn(ctx, netConn))
+		}
+
+		go func() {
+			if err := ignoreDisconnect(serveConn(ctx, netConn)); err != nil {
+				debugLog.Printf("LSP connection error: %v", err)
+			}
+		}()
+	}
+}
+
+// ignoreDisconnect drops the error of a client that went away, which is how
+// connections to a listener normally end
+func ignoreDisconnect(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// dialAndServe connects to a socket created by the client, as editors do
+// when they start the server with --pipe, and serves that one connection
+func dialAndServe(ctx context.Context, path string) error {
+	netConn, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+	debugLog.Printf("Connected to %s", path)
+	return serveConn(ctx, netConn)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"ahoy"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+func (s *Server) handleTypeDefinition(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.TypeDefinitionParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil || doc.SymbolTable == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	line := int(params.Position.Line) + 1
+	column := sourceColumn(doc.Lines, params.Position)
+
+	for _, typeName := range typeNamesAt(doc, line, column) {
+		if location := s.typeLocation(doc, typeName); location != nil {
+			return reply(ctx, location, nil)
+		}
+	}
+
+	return reply(ctx, nil, nil)
+}
+
+// typeNamesAt returns the candidate type names for the symbol at the
+// position, best first. Arrays also offer their element type.
+func typeNamesAt(doc *Document, line, column int) []string {
+	word, ok := wordSpan(doc.Lines, line, column)
+	if !ok {
+		return nil
+	}
+	text := doc.Lines[line-1]
+	name := text[word.StartColumn:word.EndColumn]
+
+	// A member after a dot: a struct field, a nested type or an enum value
+	if word.StartColumn > 0 && text[word.StartColumn-1] == '.' {
+		if receiver, ok := wordSpan(doc.Lines, line, word.StartColumn-1); ok {
+			return memberTypeNames(doc.SymbolTable, text[receiver.StartColumn:receiver.EndColumn], name, line, column)
+		}
+	}
+
+	sym, _ := doc.SymbolTable.ReferenceAt(line, column)
+	if sym == nil {
+		sym = doc.SymbolTable.LookupAt(name, line, column)
+	}
+	if sym == nil {
+		return nil
+	}
+
+	switch sym.Kind {
+	case SymbolKindStruct, SymbolKindEnum:
+		return []string{sym.Name}
+	case SymbolKindEnumValue:
+		return []string{sym.Type}
+	default:
+		return []string{sym.Type, sym.ElementType}
+	}
+}
+
+// memberTypeNames resolves the type of receiver.member
+func memberTypeNames(st *SymbolTable, receiver, member string, line, column int) []string {
+	sym := st.LookupAt(receiver, line, column)
+	if sym == nil {
+		return nil
+	}
+
+	switch sym.Kind {
+	case SymbolKindStruct:
+		// A nested type such as point.smoke_particle
+		return []string{receiver + "." + member}
+	case SymbolKindEnum:
+		return []string{receiver}
+	}
+
+	if field := st.GetStructFields(sym.Type)[member]; field != nil {
+		return []string{field.Type}
+	}
+	return nil
+}
+
+// typeLocation finds where a struct, nested type or enum is declared,
+// searching doc first and then the rest of the workspace
+func (s *Server) typeLocation(doc *Document, typeName string) *protocol.Location {
+	if typeName == "" {
+		return nil
+	}
+
+	if decl := s.resolveType(doc, typeName); decl != nil {
+		return &protocol.Location{URI: decl.doc.URI, Range: typeNameRange(decl)}
+	}
+
+	for _, candidate := range append([]*Document{doc}, s.workspace.documents()...) {
+		if candidate != doc && candidate.URI == doc.URI {
+			continue
+		}
+		if node := findEnumNode(candidate, typeName); node != nil {
+			return &protocol.Location{
+				URI:   candidate.URI,
+				Range: keywordNameRange(candidate, node.Line, "enum", node.Value),
+			}
+		}
+	}
+
+	return nil
+}
+
+// findEnumNode returns the top-level enum declaration called name
+func findEnumNode(doc *Document, name string) *ahoy.ASTNode {
+	if doc.AST == nil || strings.Contains(name, ".") {
+		return nil
+	}
+
+	for _, node := range doc.AST.Children {
+		if node.Type == ahoy.NODE_ENUM_DECLARATION && node.Value == name {
+			return node
+		}
+	}
+	return nil
+}
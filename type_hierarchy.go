@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"ahoy"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// The protocol package predates LSP 3.17, so type hierarchy is defined here
+const (
+	methodTextDocumentPrepareTypeHierarchy = "textDocument/prepareTypeHierarchy"
+	methodTypeHierarchySupertypes          = "typeHierarchy/supertypes"
+	methodTypeHierarchySubtypes            = "typeHierarchy/subtypes"
+)
+
+type typeHierarchyItem struct {
+	Name           string              `json:"name"`
+	Kind           protocol.SymbolKind `json:"kind"`
+	Detail         string              `json:"detail,omitempty"`
+	URI            uri.URI             `json:"uri"`
+	Range          protocol.Range      `json:"range"`
+	SelectionRange protocol.Range      `json:"selectionRange"`
+}
+
+type typeHierarchyParams struct {
+	Item typeHierarchyItem `json:"item"`
+}
+
+// typeDecl is a struct declaration, or one of its nested types, and the
+// document it is in. Nested types are named "struct.type" like in the
+// symbol table.
+type typeDecl struct {
+	doc    *Document
+	node   *ahoy.ASTNode
+	name   string
+	parent *ahoy.ASTNode // The enclosing struct of a nested type
+}
+
+func (s *Server) handlePrepareTypeHierarchy(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.TextDocumentPositionParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	doc := s.getDocument(params.TextDocument.URI)
+	if doc == nil || doc.AST == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	line := int(params.Position.Line) + 1
+	column := sourceColumn(doc.Lines, params.Position)
+
+	// Declarations resolve through the symbol table; uses such as
+	// point.smoke_particle are resolved from the dotted name at the cursor
+	name := ""
+	if sym, _ := doc.SymbolTable.ReferenceAt(line, column); sym != nil && sym.Kind == SymbolKindStruct {
+		name = sym.Name
+	} else {
+		name = typeNameAt(doc.Lines, line, column)
+	}
+
+	decl := s.resolveType(doc, name)
+	if decl == nil {
+		return reply(ctx, nil, nil)
+	}
+
+	return reply(ctx, []typeHierarchyItem{typeItem(decl)}, nil)
+}
+
+func (s *Server) handleTypeHierarchySupertypes(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params typeHierarchyParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	items := []typeHierarchyItem{}
+	decl := s.itemType(params.Item)
+	if decl != nil && decl.parent != nil {
+		items = append(items, typeItem(&typeDecl{doc: decl.doc, node: decl.parent, name: decl.parent.Value}))
+	}
+
+	return reply(ctx, items, nil)
+}
+
+func (s *Server) handleTypeHierarchySubtypes(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params typeHierarchyParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	items := []typeHierarchyItem{}
+	decl := s.itemType(params.Item)
+	if decl != nil && decl.parent == nil {
+		for _, child := range decl.node.Children {
+			if child.Type == ahoy.NODE_TYPE {
+				items = append(items, typeItem(&typeDecl{
+					doc:    decl.doc,
+					node:   child,
+					name:   decl.name + "." + child.Value,
+					parent: decl.node,
+				}))
+			}
+		}
+	}
+
+	return reply(ctx, items, nil)
+}
+
+// itemType finds the declaration a type hierarchy item refers to
+func (s *Server) itemType(item typeHierarchyItem) *typeDecl {
+	doc := s.documentFor(item.URI)
+	if doc == nil {
+		return nil
+	}
+	return findTypeDecl(doc, item.Name)
+}
+
+// resolveType finds the declaration of a struct or nested type by name.
+// Declarations in doc win over those in other workspace files.
+func (s *Server) resolveType(doc *Document, name string) *typeDecl {
+	if name == "" {
+		return nil
+	}
+	if decl := findTypeDecl(doc, name); decl != nil {
+		return decl
+	}
+
+	for _, other := range s.workspace.documents() {
+		if other.URI == doc.URI {
+			continue
+		}
+		if decl := findTypeDecl(other, name); decl != nil {
+			return decl
+		}
+	}
+
+	return nil
+}
+
+// findTypeDecl looks up a top-level struct, or a "struct.type" nested type,
+// in a document
+func findTypeDecl(doc *Document, name string) *typeDecl {
+	if doc.AST == nil {
+		return nil
+	}
+
+	structName, nestedName, nested := strings.Cut(name, ".")
+	for _, node := range doc.AST.Children {
+		if node.Type != ahoy.NODE_STRUCT_DECLARATION || node.Value != structName {
+			continue
+		}
+		if !nested {
+			return &typeDecl{doc: doc, node: node, name: name}
+		}
+		for _, child := range node.Children {
+			if child.Type == ahoy.NODE_TYPE && child.Value == nestedName {
+				return &typeDecl{doc: doc, node: child, name: name, parent: node}
+			}
+		}
+	}
+
+	return nil
+}
+
+// typeNameAt returns the dotted name at the position, up to and including
+// the segment under the cursor. On "point.smoke_particle" the cursor in
+// "point" gives "point" and the cursor in "smoke_particle" gives the whole
+// name.
+func typeNameAt(lines []string, line, column int) string {
+	if line <= 0 || line > len(lines) {
+		return ""
+	}
+	text := lines[line-1]
+	if column > len(text) {
+		return ""
+	}
+
+	isNameChar := func(ch byte) bool {
+		return isWordChar(rune(ch)) || ch == '.'
+	}
+
+	start := column
+	for start > 0 && isNameChar(text[start-1]) {
+		start--
+	}
+	end := column
+	for end < len(text) && isWordChar(rune(text[end])) {
+		end++
+	}
+
+	return strings.Trim(text[start:end], ".")
+}
+
+// typeItem describes a struct or nested type for the type hierarchy
+func typeItem(decl *typeDecl) typeHierarchyItem {
+	selection := typeNameRange(decl)
+
+	rng := selection
+	if span, ok := decl.doc.Spans[decl.node]; ok {
+		rng = span.toRange(decl.doc.Lines)
+	}
+
+	detail := "struct"
+	if decl.parent != nil {
+		detail = "type in " + decl.parent.Value
+	}
+
+	return typeHierarchyItem{
+		Name:           decl.name,
+		Kind:           protocol.SymbolKindStruct,
+		Detail:         detail,
+		URI:            decl.doc.URI,
+		Range:          rng,
+		SelectionRange: selection,
+	}
+}
+
+// typeNameRange is the range of the name in a struct or nested type
+// declaration
+func typeNameRange(decl *typeDecl) protocol.Range {
+	keyword := "struct"
+	if decl.parent != nil {
+		keyword = "type"
+	}
+	return keywordNameRange(decl.doc, decl.node.Line, keyword, decl.node.Value)
+}
+
+// keywordNameRange locates the name declared after keyword on a (1-based)
+// line, so a short name isn't found inside the keyword itself
+func keywordNameRange(doc *Document, line int, keyword, name string) protocol.Range {
+	column := 0
+	if line > 0 && line <= len(doc.Lines) {
+		if i := strings.Index(doc.Lines[line-1], keyword); i >= 0 {
+			column = i + len(keyword)
+		}
+	}
+	return nameRangeOnLine(doc, line, column, name)
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// maxWorkspaceSymbols caps the number of workspace/symbol results
+const maxWorkspaceSymbols = 500
+
+// workspaceSymbol is a top-level declaration found in a workspace file
+type workspaceSymbol struct {
+	Name      string
+	Kind      protocol.SymbolKind
+	Container string
+	Location  protocol.Location
+}
+
+// workspaceFile is the indexed state of one .ahoy file
+type workspaceFile struct {
+	doc     *Document
+	symbols []workspaceSymbol
+	// fromEditor marks a snapshot of the document open in the editor, as
+	// opposed to one read from disk
+	fromEditor bool
+	// revision changes every time the file is indexed again
+	revision uint64
+}
+
+// indexedDocument is the latest snapshot of an indexed file and its revision
+type indexedDocument struct {
+	doc      *Document
+	revision uint64
+}
+
+// workspaceIndex holds every .ahoy file under the workspace folders and the
+// configured include directories. Files that are open in the editor are
+// indexed from their latest snapshot and are never overwritten by the
+// background disk scan.
+type workspaceIndex struct {
+	mu       sync.RWMutex
+	roots    []string
+	includes []string
+	limits   limitSettings
+	files    map[uri.URI]*workspaceFile
+	open     map[uri.URI]bool
+	// revisions counts the snapshots indexed so far
+	revisions uint64
+}
+
+func newWorkspaceIndex() *workspaceIndex {
+	return &workspaceIndex{
+		limits: defaultSettings().Limits,
+		files:  make(map[uri.URI]*workspaceFile),
+		open:   make(map[uri.URI]bool),
+	}
+}
+
+// workspaceRoots returns the folder paths from InitializeParams, falling
+// back to the deprecated rootUri and rootPath fields
+func workspaceRoots(params protocol.InitializeParams) []string {
+	roots := []string{}
+	for _, folder := range params.WorkspaceFolders {
+		if path := folderPath(folder); path != "" {
+			roots = append(roots, path)
+		}
+	}
+
+	if len(roots) == 0 {
+		if params.RootURI != "" {
+			roots = append(roots, params.RootURI.Filename())
+		} else if params.RootPath != "" {
+			roots = append(roots, params.RootPath)
+		}
+	}
+
+	return roots
+}
+
+func folderPath(folder protocol.WorkspaceFolder) string {
+	if !strings.HasPrefix(folder.URI, "file://") {
+		return ""
+	}
+	return uri.URI(folder.URI).Filename()
+}
+
+// addRoots registers new workspace folders and indexes them in the background
+func (w *workspaceIndex) addRoots(roots []string) {
+	w.mu.Lock()
+	w.roots = append(w.roots, roots...)
+	w.mu.Unlock()
+
+	for _, root := range roots {
+		go w.scan(root)
+	}
+}
+
+// removeRoot forgets a workspace folder and every file indexed under it
+func (w *workspaceIndex) removeRoot(root string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, existing := range w.roots {
+		if existing == root {
+			w.roots = append(w.roots[:i], w.roots[i+1:]...)
+			break
+		}
+	}
+	w.forget(root)
+}
+
+// folders returns the workspace folders
+func (w *workspaceIndex) folders() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return append([]string(nil), w.roots...)
+}
+
+// configure sets the limits used for indexing and the directories indexed
+// besides the workspace folders. Include directories that are new are
+// scanned in the background.
+func (w *workspaceIndex) configure(limits limitSettings, includes []string) {
+	w.mu.Lock()
+	w.limits = limits
+	previous := w.includes
+	w.includes = includes
+
+	for _, dir := range previous {
+		if !slices.Contains(includes, dir) {
+			w.forget(dir)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, dir := range includes {
+		if !slices.Contains(previous, dir) {
+			go w.scan(dir)
+		}
+	}
+}
+
+// forget drops the files indexed under dir that are neither open nor under
+// another indexed directory. The caller must hold w.mu.
+func (w *workspaceIndex) forget(dir string) {
+	for fileURI := range w.files {
+		path := fileURI.Filename()
+		if !w.open[fileURI] && isUnder(path, dir) && !w.covers(path) {
+			delete(w.files, fileURI)
+		}
+	}
+}
+
+// covers reports whether path is under a workspace folder or include
+// directory. The caller must hold w.mu.
+func (w *workspaceIndex) covers(path string) bool {
+	for _, dirs := range [][]string{w.roots, w.includes} {
+		for _, dir := range dirs {
+			if isUnder(path, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scan walks root and indexes every .ahoy file found. Hidden directories
+// are skipped.
+func (w *workspaceIndex) scan(root string) {
+	debugLog.Printf("Indexing workspace folder %s", root)
+	count := 0
+
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if path != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".ahoy" {
+			w.indexFromDisk(uri.File(path))
+			count++
+		}
+		return nil
+	})
+
+	debugLog.Printf("Indexed %d files in %s", count, root)
+}
+
+// indexFromDisk (re)parses a file from disk unless it is open in the
+// editor. Files that no longer exist are removed from the index.
+func (w *workspaceIndex) indexFromDisk(fileURI uri.URI) {
+	w.mu.RLock()
+	open := w.open[fileURI]
+	limits := w.limits
+	w.mu.RUnlock()
+	if open {
+		return
+	}
+
+	content, err := os.ReadFile(fileURI.Filename())
+	if err != nil {
+		w.mu.Lock()
+		delete(w.files, fileURI)
+		w.mu.Unlock()
+		return
+	}
+	if len(content) > limits.MaxDocumentSize {
+		debugLog.Printf("Skipping large workspace file %s: %d bytes", fileURI, len(content))
+		w.mu.Lock()
+		if !w.open[fileURI] {
+			delete(w.files, fileURI)
+		}
+		w.mu.Unlock()
+		return
+	}
+
+	doc := parseDocument(context.Background(), fileURI, string(content), 0, time.Duration(limits.ParseTimeout))
+	if doc == nil {
+		return
+	}
+	symbols := indexSymbols(doc, limits.MaxOutlineSymbols)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// The editor may have opened the file while it was being parsed
+	if !w.open[fileURI] {
+		w.revisions++
+		w.files[fileURI] = &workspaceFile{doc: doc, symbols: symbols, revision: w.revisions}
+	}
+}
+
+// fileChanged brings the index up to date after a .ahoy file was created,
+// changed or deleted on disk. Open files keep their editor snapshot, and
+// files outside the indexed directories are ignored.
+func (w *workspaceIndex) fileChanged(fileURI uri.URI, deleted bool) {
+	w.mu.Lock()
+	if w.open[fileURI] {
+		w.mu.Unlock()
+		return
+	}
+	if deleted || !w.covers(fileURI.Filename()) {
+		delete(w.files, fileURI)
+		w.mu.Unlock()
+		return
+	}
+	w.mu.Unlock()
+
+	w.indexFromDisk(fileURI)
+}
+
+// opened marks a document as open in the editor, so the disk scan leaves
+// it alone. Snapshots from an earlier time it was open no longer count as
+// newer, since the editor may start its versions over.
+func (w *workspaceIndex) opened(fileURI uri.URI) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.open[fileURI] = true
+	if file := w.files[fileURI]; file != nil {
+		file.fromEditor = false
+	}
+}
+
+// update indexes an analyzed snapshot of an open document. Analysis runs
+// in the background, so the snapshot is dropped if the document has been
+// closed since or a newer version is already indexed.
+func (w *workspaceIndex) update(doc *Document) {
+	w.mu.RLock()
+	maxSymbols := w.limits.MaxOutlineSymbols
+	w.mu.RUnlock()
+	symbols := indexSymbols(doc, maxSymbols)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.open[doc.URI] {
+		return
+	}
+	if file := w.files[doc.URI]; file != nil && file.fromEditor && file.doc.Version > doc.Version {
+		return
+	}
+	w.revisions++
+	w.files[doc.URI] = &workspaceFile{doc: doc, symbols: symbols, fromEditor: true, revision: w.revisions}
+}
+
+// close hands a document back to the disk scan, so unsaved edits are dropped
+func (w *workspaceIndex) close(fileURI uri.URI) {
+	w.mu.Lock()
+	delete(w.open, fileURI)
+	inWorkspace := w.covers(fileURI.Filename())
+	if !inWorkspace {
+		delete(w.files, fileURI)
+	}
+	w.mu.Unlock()
+
+	if inWorkspace {
+		go w.indexFromDisk(fileURI)
+	}
+}
+
+// documents returns the latest snapshot of every indexed file, ordered by URI
+func (w *workspaceIndex) documents() []*Document {
+	indexed := w.indexedDocuments()
+	docs := make([]*Document, len(indexed))
+	for i, entry := range indexed {
+		docs[i] = entry.doc
+	}
+	return docs
+}
+
+// indexedDocuments is documents with the revision of each snapshot
+func (w *workspaceIndex) indexedDocuments() []indexedDocument {
+	w.mu.RLock()
+	indexed := make([]indexedDocument, 0, len(w.files))
+	for _, file := range w.files {
+		indexed = append(indexed, indexedDocument{file.doc, file.revision})
+	}
+	w.mu.RUnlock()
+
+	sort.Slice(indexed, func(i, j int) bool {
+		return indexed[i].doc.URI < indexed[j].doc.URI
+	})
+	return indexed
+}
+
+// document returns the indexed snapshot of a file, or nil
+func (w *workspaceIndex) document(fileURI uri.URI) *Document {
+	doc, _ := w.snapshot(fileURI)
+	return doc
+}
+
+// snapshot returns the indexed snapshot of a file and its revision, or nil
+func (w *workspaceIndex) snapshot(fileURI uri.URI) (*Document, uint64) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if file := w.files[fileURI]; file != nil {
+		return file.doc, file.revision
+	}
+	return nil, 0
+}
+
+// search returns the symbols matching query, best matches first
+func (w *workspaceIndex) search(query string) []protocol.SymbolInformation {
+	type match struct {
+		symbol workspaceSymbol
+		score  int
+	}
+
+	matches := []match{}
+	w.mu.RLock()
+	for _, file := range w.files {
+		for _, symbol := range file.symbols {
+			if score, ok := fuzzyMatch(query, symbol.Name); ok {
+				matches = append(matches, match{symbol, score})
+			}
+		}
+	}
+	w.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		if matches[i].symbol.Name != matches[j].symbol.Name {
+			return matches[i].symbol.Name < matches[j].symbol.Name
+		}
+		return matches[i].symbol.Location.URI < matches[j].symbol.Location.URI
+	})
+
+	if len(matches) > maxWorkspaceSymbols {
+		matches = matches[:maxWorkspaceSymbols]
+	}
+
+	results := make([]protocol.SymbolInformation, 0, len(matches))
+	for _, m := range matches {
+		results = append(results, protocol.SymbolInformation{
+			Name:          m.symbol.Name,
+			Kind:          m.symbol.Kind,
+			Location:      m.symbol.Location,
+			ContainerName: m.symbol.Container,
+		})
+	}
+
+	return results
+}
+
+// indexSymbols extracts the functions, structs, enums and constants of a
+// document from its outline
+func indexSymbols(doc *Document, maxSymbols int) []workspaceSymbol {
+	if doc.AST == nil {
+		return nil
+	}
+
+	outline := newOutlineBuilder(doc, maxSymbols).collect(doc.AST, map[string]bool{}, 0)
+	symbols := []workspaceSymbol{}
+
+	var flatten func(entries []protocol.DocumentSymbol, container string)
+	flatten = func(entries []protocol.DocumentSymbol, container string) {
+		for _, entry := range entries {
+			switch entry.Kind {
+			case protocol.SymbolKindFunction, protocol.SymbolKindStruct,
+				protocol.SymbolKindEnum, protocol.SymbolKindConstant:
+				symbols = append(symbols, workspaceSymbol{
+					Name:      entry.Name,
+					Kind:      entry.Kind,
+					Container: container,
+					Location: protocol.Location{
+						URI:   doc.URI,
+						Range: entry.SelectionRange,
+					},
+				})
+				flatten(entry.Children, entry.Name)
+			}
+		}
+	}
+	flatten(outline, "")
+
+	return symbols
+}
+
+// fuzzyMatch reports whether the characters of query appear in order in
+// name, ignoring case. Higher scores mean better matches: exact and prefix
+// matches rank first, then matches with fewer gaps.
+func fuzzyMatch(query, name string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+
+	q := []rune(strings.ToLower(query))
+	n := []rune(strings.ToLower(name))
+
+	score := 0
+	qi := 0
+	last := -1
+	for ni := 0; ni < len(n) && qi < len(q); ni++ {
+		if n[ni] != q[qi] {
+			continue
+		}
+
+		switch {
+		case ni == last+1:
+			// Consecutive characters
+			score += 3
+		case ni > 0 && !unicode.IsLetter(n[ni-1]) && !unicode.IsDigit(n[ni-1]):
+			// Start of a word after an underscore
+			score += 2
+		default:
+			score++
+		}
+
+		last = ni
+		qi++
+	}
+
+	if qi < len(q) {
+		return 0, false
+	}
+
+	if len(q) == len(n) {
+		score += 100
+	} else if strings.HasPrefix(string(n), string(q)) {
+		score += 50
+	}
+
+	// Prefer shorter names among otherwise equal matches
+	return score*100 - len(n), true
+}
+
+// isUnder reports whether path is root or inside it
+func isUnder(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (s *Server) handleWorkspaceSymbol(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.WorkspaceSymbolParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	return reply(ctx, s.workspace.search(params.Query), nil)
+}
+
+func (s *Server) handleDidChangeWorkspaceFolders(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+	var params protocol.DidChangeWorkspaceFoldersParams
+	if err := json.Unmarshal(req.Params(), &params); err != nil {
+		return reply(ctx, nil, err)
+	}
+
+	for _, folder := range params.Event.Removed {
+		if path := folderPath(folder); path != "" {
+			s.workspace.removeRoot(path)
+		}
+	}
+
+	added := []string{}
+	for _, folder := range params.Event.Added {
+		if path := folderPath(folder); path != "" {
+			added = append(added, path)
+		}
+	}
+	s.workspace.addRoots(added)
+
+	// Project files come and go with their folders
+	s.reloadSettings()
+	s.refreshDiagnostics(ctx)
+
+	s.mu.RLock()
+	watch := s.canWatchFiles
+	s.mu.RUnlock()
+	if watch {
+		go s.watchProjectFiles()
+	}
+
+	return reply(ctx, nil, nil)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"go.lsp.dev/uri"
+)
+
+func TestWorkspaceIndexUpdate(t *testing.T) {
+	fileURI := uri.URI("file:///tmp/ahoy-lsp-test/main.ahoy")
+	snapshot := func(version int32) *Document {
+		return &Document{URI: fileURI, Version: version}
+	}
+
+	w := newWorkspaceIndex()
+	w.opened(fileURI)
+
+	// Each step leaves the given version indexed, or nothing for -1
+	steps := []struct {
+		name string
+		run  func()
+		want int32
+	}{
+		{"first snapshot", func() { w.update(snapshot(2)) }, 2},
+		{"older snapshot finishing late", func() { w.update(snapshot(1)) }, 2},
+		{"newer snapshot", func() { w.update(snapshot(3)) }, 3},
+		{"close", func() { w.close(fileURI) }, -1},
+		{"analysis finishing after close", func() { w.update(snapshot(4)) }, -1},
+		{"reopen", func() { w.opened(fileURI) }, -1},
+		{"versions starting over", func() { w.update(snapshot(1)) }, 1},
+	}
+
+	for _, step := range steps {
+		step.run()
+
+		got := int32(-1)
+		if doc := w.document(fileURI); doc != nil {
+			got = doc.Version
+		}
+		if got != step.want {
+			t.Errorf("%s: indexed version %d, want %d", step.name, got, step.want)
+		}
+	}
+}